@@ -4,8 +4,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"os"
-	"runtime"
+	"math"
 	"sync"
 	"time"
 
@@ -23,20 +22,22 @@ type AudioMixer struct {
 	sampleRate     int
 	bytesPerSample int
 	paused         bool
-	elapsedTime    float64
 }
 
 type playback struct {
-	id            int
-	file          *os.File
-	done          chan struct{}
-	buffer        []byte
-	valid         int
-	volume        float64
-	totalBytes    int64
-	bytesRead     int64
-	mu            sync.RWMutex
-	stopRequested bool
+	id int
+	// the decoded file after any silence it starts with, nil for a clock
+	audio  *decodedAudio
+	source io.Reader
+	// frame of the file the playback started at, negative when it starts with silence
+	origin     int64
+	done       chan struct{}
+	buffer     []byte
+	valid      int
+	volume     float64
+	totalBytes int64
+	bytesRead  int64
+	mu         sync.RWMutex
 }
 
 type PlaybackHandle struct {
@@ -44,12 +45,16 @@ type PlaybackHandle struct {
 	am *AudioMixer
 }
 
+// stops the playback right away, this does not wait for the audio session to mix again
+// so it is safe to call without an audio connection
 func (ph *PlaybackHandle) Stop() {
-	ph.pb.mu.Lock()
-	ph.pb.stopRequested = true
-	ph.pb.mu.Unlock()
+	ph.am.mu.Lock()
+	defer ph.am.mu.Unlock()
 
-	<-ph.pb.done
+	if _, exists := ph.am.playing[ph.pb.id]; exists {
+		close(ph.pb.done)
+		delete(ph.am.playing, ph.pb.id)
+	}
 }
 
 func (ph *PlaybackHandle) Progress() float64 {
@@ -67,6 +72,16 @@ func (ph *PlaybackHandle) Progress() float64 {
 	return progress
 }
 
+// time in the file of the audio mixed so far, negative while the silence before it is mixed.
+// It only moves while the mixer mixes, so it stops with pauses and stalled audio connections.
+func (ph *PlaybackHandle) Position() time.Duration {
+	ph.pb.mu.RLock()
+	defer ph.pb.mu.RUnlock()
+
+	frames := ph.pb.origin + ph.pb.bytesRead/int64(ph.am.channels*ph.am.bytesPerSample)
+	return time.Duration(frames * int64(time.Second) / int64(ph.am.sampleRate))
+}
+
 func (ph *PlaybackHandle) IsPlaying() bool {
 	ph.am.mu.Lock()
 	defer ph.am.mu.Unlock()
@@ -107,6 +122,11 @@ func (am *AudioMixer) Period() time.Duration {
 	return time.Duration(am.framesPerWrite) * time.Second / time.Duration(am.sampleRate)
 }
 
+// the frame played at the given time
+func (am *AudioMixer) frameAt(t time.Duration) int64 {
+	return int64(t.Seconds() * float64(am.sampleRate))
+}
+
 func (am *AudioMixer) Pause() {
 	am.mu.Lock()
 	defer am.mu.Unlock()
@@ -133,46 +153,218 @@ func (am *AudioMixer) TogglePause() bool {
 }
 
 func (am *AudioMixer) Play(filePath string, volume float64) (*PlaybackHandle, error) {
-	f, err := os.Open(filePath)
+	return am.PlaySection(filePath, volume, 0, 0)
+}
+
+// plays the part of the file between start and end, or until the end of the file if end is 0.
+// A negative start plays that much silence first. The file is decoded and resampled to the
+// mixer's format.
+func (am *AudioMixer) PlaySection(filePath string, volume float64, start time.Duration, end time.Duration) (*PlaybackHandle, error) {
+	pb, err := am.open(filePath, volume, start, end)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open audio file: %w", err)
+		return nil, err
 	}
+	return am.start(pb)[0], nil
+}
 
-	fileInfo, err := f.Stat()
+// opens the file for a playback which hasn't started yet
+func (am *AudioMixer) open(filePath string, volume float64, start time.Duration, end time.Duration) (*playback, error) {
+	audio, err := openAudio(filePath, am.Format())
 	if err != nil {
-		f.Close()
-		return nil, fmt.Errorf("failed to get file info: %w", err)
+		return nil, err
 	}
 
-	pb := &playback{
-		file:       f,
+	// files whose length can't be worked out play until they end
+	endFrame := audio.Length()
+	if endFrame < 0 {
+		endFrame = math.MaxInt64
+	}
+	if end > 0 {
+		endFrame = min(endFrame, am.frameAt(end))
+	}
+	startFrame := min(am.frameAt(start), endFrame)
+	if err := audio.SetPosition(max(0, startFrame)); err != nil {
+		audio.Close()
+		return nil, fmt.Errorf("failed to seek audio file: %w", err)
+	}
+
+	frameSize := int64(am.channels * am.bytesPerSample)
+	silence := max(0, -startFrame) * frameSize
+	totalBytes := int64(math.MaxInt64)
+	if endFrame != math.MaxInt64 {
+		totalBytes = (endFrame-max(0, startFrame))*frameSize + silence
+	}
+
+	return &playback{
+		audio:      audio,
+		source:     io.MultiReader(io.LimitReader(zeros{}, silence), audio),
+		origin:     startFrame,
 		done:       make(chan struct{}),
 		buffer:     make([]byte, am.BufferSize()),
 		volume:     volume,
-		totalBytes: fileInfo.Size(),
+		totalBytes: totalBytes,
 		bytesRead:  0,
-	}
+	}, nil
+}
 
+// adds the playbacks to the mixer together, so they are first mixed into the same write
+func (am *AudioMixer) start(pbs ...*playback) []*PlaybackHandle {
 	am.mu.Lock()
-	pb.id = am.nextID
-	am.nextID++
-	am.playing[pb.id] = pb
-	am.mu.Unlock()
-
-	handle := &PlaybackHandle{
-		pb: pb,
-		am: am,
+	defer am.mu.Unlock()
+
+	handles := make([]*PlaybackHandle, len(pbs))
+	for i, pb := range pbs {
+		pb.id = am.nextID
+		am.nextID++
+		am.playing[pb.id] = pb
+		handles[i] = &PlaybackHandle{
+			pb: pb,
+			am: am,
+		}
+
+		go func() {
+			<-pb.done
+			am.mu.Lock()
+			delete(am.playing, pb.id)
+			am.mu.Unlock()
+			if pb.audio != nil {
+				pb.audio.Close()
+			}
+		}()
+	}
+	return handles
+}
+
+// plays 16 bit PCM in the mixer's format read from source, such as generated sounds, until it ends
+func (am *AudioMixer) PlayStream(source io.Reader, volume float64) *PlaybackHandle {
+	return am.start(&playback{
+		source:     source,
+		done:       make(chan struct{}),
+		buffer:     make([]byte, am.BufferSize()),
+		volume:     volume,
+		totalBytes: math.MaxInt64,
+	})[0]
+}
+
+// an endless stream of silence
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// playbacks which started on the same sample, such as the stems of a song
+type PlaybackGroup struct {
+	handles map[string]*PlaybackHandle
+	// silence which keeps playing after the files end, nil unless the group is a song
+	clock *PlaybackHandle
+}
+
+// opens every file in the map between start and end, leaving out the ones which fail so a broken
+// stem doesn't lose the whole song. The names are the keys of the files which opened.
+func (am *AudioMixer) openStems(filePaths map[string]string, volume float64, start time.Duration, end time.Duration) ([]string, []*playback) {
+	names := make([]string, 0, len(filePaths))
+	pbs := make([]*playback, 0, len(filePaths)+1)
+	for name, filePath := range filePaths {
+		pb, err := am.open(filePath, volume, start, end)
+		if err != nil {
+			log.Error("failed to play stem", "stem", name, "error", err)
+			continue
+		}
+		names = append(names, name)
+		pbs = append(pbs, pb)
+	}
+	return names, pbs
+}
+
+// plays every file in the map between start and end, starting them on the same sample so they
+// stay in sync. The handles are looked up by the key of their file. Files which can't be opened
+// are left out, it only fails if none of them can.
+func (am *AudioMixer) PlayGroup(filePaths map[string]string, volume float64, start time.Duration, end time.Duration) (*PlaybackGroup, error) {
+	names, pbs := am.openStems(filePaths, volume, start, end)
+	if len(pbs) == 0 {
+		return nil, fmt.Errorf("none of the %d files could be played", len(filePaths))
+	}
+
+	group := &PlaybackGroup{handles: make(map[string]*PlaybackHandle, len(pbs))}
+	for i, handle := range am.start(pbs...) {
+		group.handles[names[i]] = handle
+	}
+	return group, nil
+}
+
+// plays the song's stems from start, which is negative to lead in with silence. The group's
+// position keeps counting after the stems end until it is stopped, so it can clock the game. A
+// stem that can't be opened is left out rather than losing the whole song.
+func (am *AudioMixer) PlaySong(filePaths map[string]string, volume float64, start time.Duration) *PlaybackGroup {
+	names, pbs := am.openStems(filePaths, volume, start, 0)
+	pbs = append(pbs, &playback{
+		source:     zeros{},
+		origin:     am.frameAt(start),
+		done:       make(chan struct{}),
+		buffer:     make([]byte, am.BufferSize()),
+		totalBytes: math.MaxInt64,
+	})
+
+	group := &PlaybackGroup{handles: make(map[string]*PlaybackHandle, len(names))}
+	handles := am.start(pbs...)
+	for i, name := range names {
+		group.handles[name] = handles[i]
+	}
+	group.clock = handles[len(names)]
+	return group
+}
+
+// time in the song of the audio mixed so far, see PlaybackHandle.Position
+func (g *PlaybackGroup) Position() time.Duration {
+	if g.clock != nil {
+		return g.clock.Position()
 	}
+	for _, handle := range g.handles {
+		return handle.Position()
+	}
+	return 0
+}
+
+// the playback of the named file, nil if the group has none
+func (g *PlaybackGroup) Get(name string) *PlaybackHandle {
+	return g.handles[name]
+}
 
-	go func() {
-		<-pb.done
-		am.mu.Lock()
-		delete(am.playing, pb.id)
-		am.mu.Unlock()
-		f.Close()
-	}()
+func (g *PlaybackGroup) Stop() {
+	for _, handle := range g.handles {
+		handle.Stop()
+	}
+	g.StopClock()
+}
 
-	return handle, nil
+// stops counting the position while letting the files play out
+func (g *PlaybackGroup) StopClock() {
+	if g.clock != nil {
+		g.clock.Stop()
+	}
+}
+
+// whether any playback of the group is still going
+func (g *PlaybackGroup) IsPlaying() bool {
+	for _, handle := range g.handles {
+		if handle.IsPlaying() {
+			return true
+		}
+	}
+	return false
+}
+
+// stops every playback, once the session is closed
+func (am *AudioMixer) StopAll() {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	for id, pb := range am.playing {
+		close(pb.done)
+		delete(am.playing, id)
+	}
 }
 
 func (am *AudioMixer) FillBuffers() {
@@ -184,19 +376,19 @@ func (am *AudioMixer) FillBuffers() {
 	}
 
 	for id, pb := range am.playing {
-		pb.mu.RLock()
-		stopRequested := pb.stopRequested
-		pb.mu.RUnlock()
-
-		if stopRequested {
-			close(pb.done)
-			delete(am.playing, id)
-			continue
-		}
-
 		pb.valid = 0
 		for pb.valid < len(pb.buffer) {
-			n, err := pb.file.Read(pb.buffer[pb.valid:])
+			// don't read past the end of the section being played
+			pb.mu.RLock()
+			left := pb.totalBytes - pb.bytesRead
+			pb.mu.RUnlock()
+			if left <= 0 {
+				close(pb.done)
+				delete(am.playing, id)
+				break
+			}
+
+			n, err := pb.source.Read(pb.buffer[pb.valid : pb.valid+int(min(int64(len(pb.buffer)-pb.valid), left))])
 			if n > 0 {
 				pb.valid += n
 				pb.mu.Lock()
@@ -209,7 +401,7 @@ func (am *AudioMixer) FillBuffers() {
 					delete(am.playing, id)
 					break
 				}
-				log.Errorf("error reading audio file: %v", err)
+				log.Errorf("error decoding audio file: %v", err)
 				close(pb.done)
 				delete(am.playing, id)
 				break
@@ -238,63 +430,78 @@ func (am *AudioMixer) MixInto(buffer []byte) {
 		pb.mu.RLock()
 		effectiveVolume := am.mixAmp * pb.volume
 		pb.mu.RUnlock()
+		// muted stems and clocks add nothing
+		if effectiveVolume == 0 {
+			continue
+		}
 
-		for frame := range framesToMix {
-			frameOffset := frame * am.channels * am.bytesPerSample
-
-			// Decode and mix left channel
-			mixL := int16(binary.LittleEndian.Uint16(pb.buffer[frameOffset:]))
-			mixLf := (float64(mixL) / maxInt16) * effectiveVolume
+		for sample := range framesToMix * am.channels {
+			offset := sample * am.bytesPerSample
 
-			origL := int16(binary.LittleEndian.Uint16(buffer[frameOffset:]))
-			origLF := float64(origL) / maxInt16
-			mixedLF := origLF + mixLf
-			if mixedLF > 1.0 {
-				mixedLF = 1.0
-			} else if mixedLF < -1.0 {
-				mixedLF = -1.0
-			}
-			mixedL := int16(mixedLF * maxInt16)
-			binary.LittleEndian.PutUint16(buffer[frameOffset:], uint16(mixedL))
-
-			// Decode and mix right channel
-			mixR := int16(binary.LittleEndian.Uint16(pb.buffer[frameOffset+2:]))
-			mixRf := (float64(mixR) / maxInt16) * effectiveVolume
-
-			origR := int16(binary.LittleEndian.Uint16(buffer[frameOffset+2:]))
-			origRF := float64(origR) / maxInt16
-			mixedRF := origRF + mixRf
-			if mixedRF > 1.0 {
-				mixedRF = 1.0
-			} else if mixedRF < -1.0 {
-				mixedRF = -1.0
-			}
-			mixedR := int16(mixedRF * maxInt16)
-			binary.LittleEndian.PutUint16(buffer[frameOffset+2:], uint16(mixedR))
+			mix := float64(int16(binary.LittleEndian.Uint16(pb.buffer[offset:]))) / maxInt16
+			orig := float64(int16(binary.LittleEndian.Uint16(buffer[offset:]))) / maxInt16
+			mixed := max(-1, min(1, orig+mix*effectiveVolume))
+			binary.LittleEndian.PutUint16(buffer[offset:], uint16(int16(mixed*maxInt16)))
 		}
 	}
 }
 
-func sendAudio(s ssh.Session, mixer *AudioMixer) {
-	runtime.LockOSThread()
-	startTime := time.Now()
+// how far ahead of real time the audio stream is written
+var AudioLatency = 50 * time.Millisecond
+
+// streams the mixer to the session in the encoding until it disconnects or done is closed
+func sendAudio(s ssh.Session, mixer *AudioMixer, encoding AudioEncoding, done <-chan struct{}) {
+	w, err := encoding.encoder(s, mixer.Format())
+	if err != nil {
+		log.Errorf("stopped sending audio: %v", err)
+		return
+	}
+	if err := streamAudio(w, mixer, done, AudioLatency); err != nil {
+		log.Infof("stopped sending audio: %v", err)
+		return
+	}
+	log.Info("stopped sending audio: session closed")
+}
+
+// writes the mixer to w, keeping latency worth of audio ahead of real time. The schedule is
+// worked out from the start of the stream rather than the last wake up, so late timers don't add
+// up into drift, and the goroutine sleeps between writes instead of spinning.
+func streamAudio(w io.Writer, mixer *AudioMixer, done <-chan struct{}, latency time.Duration) error {
 	buffer := make([]byte, mixer.BufferSize())
-	nextTick := time.Now()
+	start := time.Now()
+	// frames written so far, counted in frames so the stream length doesn't round off
+	var frames int64
+	written := func() time.Duration {
+		return time.Duration(frames * int64(time.Second) / int64(mixer.sampleRate))
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
 	for {
-		nextTick = nextTick.Add(mixer.Period())
-		// time.Sleep(time.Until(nextTick))
-		for time.Now().Before(nextTick) {
+		select {
+		case <-done:
+			return nil
+		case <-timer.C:
 		}
-		clear(buffer)
-		mixer.FillBuffers()
-		mixer.MixInto(buffer)
-		_, err := s.Write(buffer)
-		if err != nil {
-			log.Infof("stopped sending audio: %v", err)
-			return
+
+		elapsed := time.Since(start)
+		// the connection stalled for longer than the lead, so skip the audio that should have played
+		// meanwhile instead of bursting it out late
+		if written()+latency < elapsed {
+			log.Debug("audio stream fell behind", "behind", elapsed-written())
+			frames = int64(elapsed.Seconds() * float64(mixer.sampleRate))
 		}
-		mixer.mu.Lock()
-		mixer.elapsedTime = time.Since(startTime).Seconds()
-		mixer.mu.Unlock()
+		for written() < elapsed+latency {
+			clear(buffer)
+			mixer.FillBuffers()
+			mixer.MixInto(buffer)
+			if _, err := w.Write(buffer); err != nil {
+				return err
+			}
+			frames += int64(mixer.framesPerWrite)
+		}
+
+		// wake up once a write's worth of the lead has played
+		timer.Reset(written() - latency + mixer.Period() - time.Since(start))
 	}
 }
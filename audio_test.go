@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"runtime"
+	"runtime/metrics"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	benchSessions = flag.Int("sessions", 100, "audio sessions BenchmarkStreamAudio streams at once")
+	benchAudio    = flag.String("audio", "", "raw file every session of BenchmarkStreamAudio plays, silence if empty")
+)
+
+// records how far ahead of real time each write of an audio stream is
+type leadRecorder struct {
+	mixer  *AudioMixer
+	start  time.Time
+	frames int64
+	leads  []time.Duration
+}
+
+func (r *leadRecorder) Write(p []byte) (int, error) {
+	// the first write starts the stream, so it has no lead to measure yet
+	if r.start.IsZero() {
+		r.start = time.Now()
+	} else {
+		written := time.Duration(r.frames * int64(time.Second) / int64(r.mixer.sampleRate))
+		r.leads = append(r.leads, written-time.Since(r.start))
+	}
+	r.frames += int64(len(p) / (r.mixer.channels * r.mixer.bytesPerSample))
+	return len(p), nil
+}
+
+// CPU time the process has used, as estimated by the runtime. The estimate only moves on at
+// garbage collection, so one is run first.
+func cpuTime() time.Duration {
+	runtime.GC()
+	samples := []metrics.Sample{
+		{Name: "/cpu/classes/total:cpu-seconds"},
+		{Name: "/cpu/classes/idle:cpu-seconds"},
+	}
+	metrics.Read(samples)
+	return time.Duration((samples[0].Value.Float64() - samples[1].Value.Float64()) * float64(time.Second))
+}
+
+// streams many sessions at once for a second each iteration, reporting the CPU a session uses and
+// how steady their lead over real time is. Run with
+// `go test -run - -bench StreamAudio -sessions 100`.
+func BenchmarkStreamAudio(b *testing.B) {
+	const bytesPerSample = 2
+	settings := DefaultConfig().Audio
+
+	var leads []time.Duration
+	var cpu, wall time.Duration
+	for b.Loop() {
+		done := make(chan struct{})
+		recorders := make([]*leadRecorder, *benchSessions)
+		var wg sync.WaitGroup
+		for i := range recorders {
+			mixer := NewAudioMixer(settings.Channels, settings.MixAmp, settings.FramesPerWrite, settings.SampleRate, bytesPerSample)
+			if *benchAudio != "" {
+				if _, err := mixer.Play(*benchAudio, 1); err != nil {
+					b.Fatal(err)
+				}
+			}
+			recorders[i] = &leadRecorder{mixer: mixer}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_ = streamAudio(recorders[i], mixer, done, AudioLatency)
+			}()
+		}
+
+		cpuStart := cpuTime()
+		wallStart := time.Now()
+		time.Sleep(time.Second)
+		close(done)
+		wg.Wait()
+		cpu += cpuTime() - cpuStart
+		wall += time.Since(wallStart)
+		for _, recorder := range recorders {
+			leads = append(leads, recorder.leads...)
+		}
+	}
+	if len(leads) == 0 {
+		b.Fatal("no audio was written")
+	}
+
+	var sum, sumSquares float64
+	underruns := 0
+	for _, lead := range leads {
+		sum += lead.Seconds()
+		sumSquares += lead.Seconds() * lead.Seconds()
+		if lead < 0 {
+			underruns++
+		}
+	}
+	mean := sum / float64(len(leads))
+	jitter := math.Sqrt(max(0, sumSquares/float64(len(leads))-mean*mean))
+
+	b.ReportMetric(100*cpu.Seconds()/wall.Seconds()/float64(*benchSessions), "%cpu/session")
+	b.ReportMetric(jitter*1e6, "µs-jitter")
+	b.ReportMetric(mean*1e6, "µs-lead")
+	b.ReportMetric(float64(underruns), "underruns")
+}
+
+func TestPlayGroupSkipsBrokenStems(t *testing.T) {
+	mixer := NewAudioMixer(2, 1.0, 128, 44100, 2)
+	group, err := mixer.PlayGroup(map[string]string{"song": "strum.raw", "guitar": "missing.ogg"}, 1.0, 0, time.Second)
+	if err != nil {
+		t.Fatalf("PlayGroup() error = %v", err)
+	}
+	defer group.Stop()
+	if group.Get("song") == nil {
+		t.Error("stem which opened is not playing")
+	}
+	if group.Get("guitar") != nil {
+		t.Error("stem which failed to open is playing")
+	}
+
+	if _, err := mixer.PlayGroup(map[string]string{"guitar": "missing.ogg"}, 1.0, 0, time.Second); err == nil {
+		t.Error("PlayGroup() without any stem which opens succeeded")
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/charmbracelet/ssh"
+)
+
+// the PCM stream an audio session sends
+type AudioFormat struct {
+	SampleRate    int
+	Channels      int
+	BitsPerSample int
+}
+
+func (am *AudioMixer) Format() AudioFormat {
+	return AudioFormat{SampleRate: am.sampleRate, Channels: am.channels, BitsPerSample: am.bytesPerSample * 8}
+}
+
+// a program players can pipe the audio session into
+type AudioPlayer struct {
+	Name string
+	// arguments which play each encoding from stdin, encodings the player can't play are missing
+	commands map[string]func(f AudioFormat) string
+}
+
+func channelLayout(f AudioFormat) string {
+	if f.Channels == 1 {
+		return "mono"
+	}
+	return "stereo"
+}
+
+var AudioPlayers = []AudioPlayer{
+	{"aplay", map[string]func(f AudioFormat) string{
+		"raw": func(f AudioFormat) string {
+			return fmt.Sprintf("aplay -f S%d_LE -c %d -r %d --buffer-size 1024", f.BitsPerSample, f.Channels, f.SampleRate)
+		},
+		"wav": func(f AudioFormat) string {
+			return "aplay --buffer-size 1024"
+		},
+		"mulaw": func(f AudioFormat) string {
+			return fmt.Sprintf("aplay -f MU_LAW -c %d -r %d --buffer-size 1024", f.Channels, f.SampleRate)
+		},
+	}},
+	{"pacat", map[string]func(f AudioFormat) string{
+		"raw": func(f AudioFormat) string {
+			return fmt.Sprintf("pacat --format=s%dle --channels=%d --rate=%d --latency-msec=50", f.BitsPerSample, f.Channels, f.SampleRate)
+		},
+		"mulaw": func(f AudioFormat) string {
+			return fmt.Sprintf("pacat --format=ulaw --channels=%d --rate=%d --latency-msec=50", f.Channels, f.SampleRate)
+		},
+	}},
+	{"ffplay", map[string]func(f AudioFormat) string{
+		"raw": func(f AudioFormat) string {
+			return fmt.Sprintf("ffplay -nodisp -loglevel quiet -fflags nobuffer -f s%dle -ar %d -ch_layout %s -i -", f.BitsPerSample, f.SampleRate, channelLayout(f))
+		},
+		"wav": func(f AudioFormat) string {
+			return "ffplay -nodisp -loglevel quiet -fflags nobuffer -f wav -i -"
+		},
+		"mulaw": func(f AudioFormat) string {
+			return fmt.Sprintf("ffplay -nodisp -loglevel quiet -fflags nobuffer -f mulaw -ar %d -ch_layout %s -i -", f.SampleRate, channelLayout(f))
+		},
+		"flac": func(f AudioFormat) string {
+			return "ffplay -nodisp -loglevel quiet -fflags nobuffer -f flac -i -"
+		},
+		"ogg": func(f AudioFormat) string {
+			return "ffplay -nodisp -loglevel quiet -fflags nobuffer -f ogg -i -"
+		},
+	}},
+	{"mpv", map[string]func(f AudioFormat) string{
+		"raw": func(f AudioFormat) string {
+			return fmt.Sprintf("mpv --no-video --cache=no --demuxer=rawaudio --demuxer-rawaudio-format=s%dle --demuxer-rawaudio-rate=%d --demuxer-rawaudio-channels=%d -", f.BitsPerSample, f.SampleRate, f.Channels)
+		},
+		"wav": func(f AudioFormat) string {
+			return "mpv --no-video --cache=no -"
+		},
+		"mulaw": func(f AudioFormat) string {
+			return fmt.Sprintf("mpv --no-video --cache=no --demuxer=rawaudio --demuxer-rawaudio-format=mulaw --demuxer-rawaudio-rate=%d --demuxer-rawaudio-channels=%d -", f.SampleRate, f.Channels)
+		},
+		"flac": func(f AudioFormat) string {
+			return "mpv --no-video --cache=no -"
+		},
+		"ogg": func(f AudioFormat) string {
+			return "mpv --no-video --cache=no -"
+		},
+	}},
+	{"sox", map[string]func(f AudioFormat) string{
+		"raw": func(f AudioFormat) string {
+			return fmt.Sprintf("play -q -t raw -e signed -b %d -c %d -r %d -", f.BitsPerSample, f.Channels, f.SampleRate)
+		},
+		"wav": func(f AudioFormat) string {
+			return "play -q -t wav -"
+		},
+		"mulaw": func(f AudioFormat) string {
+			return fmt.Sprintf("play -q -t raw -e mu-law -b 8 -c %d -r %d -", f.Channels, f.SampleRate)
+		},
+		"flac": func(f AudioFormat) string {
+			return "play -q -t flac -"
+		},
+	}},
+}
+
+func audioPlayerIndex(name string) int {
+	for i, player := range AudioPlayers {
+		if player.Name == name {
+			return i
+		}
+	}
+	return 0
+}
+
+// where a player's audio connection has to go to be paired with their game
+type sshTarget struct {
+	user string
+	host string
+	port int
+}
+
+// uses the configured public address, falling back to the address the game session connected to
+func newSSHTarget(sess ssh.Session, server ServerConfig) sshTarget {
+	target := sshTarget{user: sess.User(), host: server.PublicHost, port: server.PublicPort}
+	if target.host == "" {
+		if addr, ok := sess.LocalAddr().(*net.TCPAddr); ok {
+			target.host = addr.IP.String()
+		} else {
+			target.host = server.Host
+		}
+	}
+	if target.port == 0 {
+		target.port = server.Port
+	}
+	return target
+}
+
+// the full command a player runs to hear the game, pairing with the code. Fails if the player
+// can't play the encoding.
+func (t sshTarget) audioCommand(player AudioPlayer, encoding AudioEncoding, format AudioFormat, code string) (string, error) {
+	command, ok := player.commands[encoding.Name]
+	if !ok {
+		return "", fmt.Errorf("%s can't play %s, pick another player or format", player.Name, encoding.Name)
+	}
+	port := ""
+	if t.port != 22 {
+		port = "-p " + strconv.Itoa(t.port) + " "
+	}
+	formatFlag := ""
+	if encoding.Name != "raw" {
+		formatFlag = " --format " + encoding.Name
+	}
+	return fmt.Sprintf("ssh -T %s-o UserKnownHostsFile=/dev/null -o StrictHostKeyChecking=no %s@%s pair %s%s | %s", port, t.user, t.host, code, formatFlag, command(format)), nil
+}
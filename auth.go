@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/charmbracelet/log"
+	"github.com/charmbracelet/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+type AuthMode string
+
+const (
+	// any public key may connect
+	AuthOpen AuthMode = "open"
+	// only keys in the authorized keys file may connect
+	AuthAllowlist AuthMode = "allowlist"
+)
+
+// context keys set while authenticating a connection
+const (
+	guestKey  = "guest"
+	bannedKey = "banned"
+)
+
+// decides who may connect to the server
+type AuthPolicy struct {
+	Mode AuthMode
+	// fingerprints of the keys allowed in allowlist mode
+	allowed map[string]bool
+	// fingerprints of the keys which may never connect, whatever the mode
+	banned map[string]bool
+	// let clients without an accepted key in through keyboard-interactive as a guest
+	Guests bool
+}
+
+// builds a policy, reading the allowlist and denylist from authorized_keys style files. Empty
+// paths are skipped.
+func NewAuthPolicy(mode AuthMode, authorizedKeys string, bannedKeys string, guests bool) (*AuthPolicy, error) {
+	policy := &AuthPolicy{Mode: mode, Guests: guests, allowed: map[string]bool{}, banned: map[string]bool{}}
+	switch mode {
+	case AuthOpen:
+	case AuthAllowlist:
+		if authorizedKeys == "" {
+			return nil, fmt.Errorf("allowlist auth needs an authorized keys file")
+		}
+	default:
+		return nil, fmt.Errorf("unknown auth mode %q, expected %q or %q", mode, AuthOpen, AuthAllowlist)
+	}
+
+	var err error
+	if authorizedKeys != "" {
+		policy.allowed, err = readKeyFile(authorizedKeys)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if bannedKeys != "" {
+		policy.banned, err = readKeyFile(bannedKeys)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+// returns the fingerprints of the keys in an authorized_keys style file
+func readKeyFile(path string) (map[string]bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keys: %w", err)
+	}
+
+	keys := map[string]bool{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 || text[0] == '#' {
+			continue
+		}
+		key, _, _, _, err := gossh.ParseAuthorizedKey(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		keys[gossh.FingerprintSHA256(key)] = true
+	}
+	return keys, scanner.Err()
+}
+
+func (p *AuthPolicy) reject(ctx ssh.Context, reason string, keyvals ...any) bool {
+	log.Warn("rejected connection", append([]any{"user", ctx.User(), "remote", ctx.RemoteAddr(), "reason", reason}, keyvals...)...)
+	return false
+}
+
+func (p *AuthPolicy) PublicKeyHandler(ctx ssh.Context, key ssh.PublicKey) bool {
+	fingerprint := gossh.FingerprintSHA256(key)
+	if p.banned[fingerprint] {
+		// remembered so the same connection can't come back in as a guest
+		ctx.SetValue(bannedKey, true)
+		return p.reject(ctx, "banned key", "fingerprint", fingerprint)
+	}
+	if p.Mode == AuthAllowlist && !p.allowed[fingerprint] {
+		return p.reject(ctx, "key not in allowlist", "fingerprint", fingerprint)
+	}
+	return true
+}
+
+// lets keyless clients in as a guest with a generated name, without asking them anything
+func (p *AuthPolicy) KeyboardInteractiveHandler(ctx ssh.Context, challenger gossh.KeyboardInteractiveChallenge) bool {
+	if !p.Guests {
+		return p.reject(ctx, "guests are disabled")
+	}
+	if banned, _ := ctx.Value(bannedKey).(bool); banned {
+		return p.reject(ctx, "banned key tried to connect as a guest")
+	}
+	name, err := guestName()
+	if err != nil {
+		log.Error("failed to generate guest name", "error", err)
+		return false
+	}
+	ctx.SetValue(guestKey, name)
+	log.Info("guest connected", "user", ctx.User(), "remote", ctx.RemoteAddr(), "guest", name)
+	return true
+}
+
+func guestName() (string, error) {
+	id := make([]byte, 3)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return "guest-" + hex.EncodeToString(id), nil
+}
+
+// returns the generated guest name of a session, or an empty string if it signed in with a key
+func GuestName(sess ssh.Session) string {
+	name, _ := sess.Context().Value(guestKey).(string)
+	return name
+}
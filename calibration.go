@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea/v2"
+	"github.com/charmbracelet/lipgloss/v2"
+	"github.com/charmbracelet/log"
+)
+
+const (
+	CALIBRATE_AUDIO = iota
+	CALIBRATE_VIDEO
+	CALIBRATE_MAX = iota - 1
+)
+
+const (
+	// time between beats, slow enough that a late tap is still nearest to the beat it was meant for
+	calibrationBeat = 750 * time.Millisecond
+	// taps a test takes, the offset is their median so a few stray taps don't matter
+	calibrationTaps = 16
+	// how long the beat is shown for in the video test
+	calibrationFlash = 100 * time.Millisecond
+	// milliseconds the offsets are nudged by with the arrow keys
+	offsetStep = 5
+	maxOffset  = 500
+)
+
+// a click on every beat, accenting the first of each bar
+type metronome struct {
+	format AudioFormat
+	frame  int64
+}
+
+func (s *metronome) Read(p []byte) (int, error) {
+	const clickLength = 0.03
+
+	frameSize := s.format.Channels * 2
+	beatFrames := int64(calibrationBeat.Seconds() * float64(s.format.SampleRate))
+	n := len(p) - len(p)%frameSize
+	for i := 0; i < n; i += frameSize {
+		beat := s.frame / beatFrames
+		t := float64(s.frame%beatFrames) / float64(s.format.SampleRate)
+		sample := 0.0
+		if t < clickLength {
+			pitch := 1500.0
+			if beat%4 == 0 {
+				pitch = 2000.0
+			}
+			sample = 0.6 * math.Sin(2*math.Pi*pitch*t) * math.Exp(-t/(clickLength/4))
+		}
+		for c := range s.format.Channels {
+			binary.LittleEndian.PutUint16(p[i+c*2:], uint16(int16(sample*math.MaxInt16)))
+		}
+		s.frame++
+	}
+	return n, nil
+}
+
+type calibrationTickMsg struct {
+	run int
+}
+
+// measures how late a player taps along to clicks they hear and to beats they see, which is how
+// long audio and the terminal take to reach them plus how long their keys take to come back
+type CalibrationScreen struct {
+	width    int
+	height   int
+	menu     Menu
+	selected int
+	// whether a test is running, and which one counts as selected
+	testing bool
+	// tells the ticks of an old test apart from the current one
+	run int
+	// the clicks of the audio test, whose position the taps are timed against
+	clicks *PlaybackHandle
+	// when the video test started
+	start time.Time
+	// how far each tap was from the nearest beat
+	taps    []time.Duration
+	message string
+}
+
+func NewCalibrationScreen(menu Menu) CalibrationScreen {
+	return CalibrationScreen{width: menu.width, height: menu.height, menu: menu}
+}
+
+func (m CalibrationScreen) Init() tea.Cmd {
+	return nil
+}
+
+func (m CalibrationScreen) tick() tea.Cmd {
+	run := m.run
+	return tea.Tick(10*time.Millisecond, func(time.Time) tea.Msg {
+		return calibrationTickMsg{run}
+	})
+}
+
+// time of the current test, from the clicks mixed for the audio test so it lines up with the
+// game clock
+func (m CalibrationScreen) elapsed() time.Duration {
+	if m.selected == CALIBRATE_AUDIO {
+		return m.clicks.Position() - AudioLatency
+	}
+	return time.Since(m.start)
+}
+
+func (m CalibrationScreen) offset(test int) *int {
+	if test == CALIBRATE_AUDIO {
+		return &m.menu.profile.Settings.AudioOffset
+	}
+	return &m.menu.profile.Settings.VideoOffset
+}
+
+func (m CalibrationScreen) startTest() (CalibrationScreen, tea.Cmd) {
+	m.testing = true
+	m.run++
+	m.taps = nil
+	m.message = ""
+	if m.selected == CALIBRATE_AUDIO {
+		m.clicks = m.menu.mixer.PlayStream(&metronome{format: m.menu.mixer.Format()}, 1.0)
+		return m, nil
+	}
+	m.start = time.Now()
+	return m, m.tick()
+}
+
+func (m CalibrationScreen) stopTest() CalibrationScreen {
+	m.testing = false
+	if m.clicks != nil {
+		m.clicks.Stop()
+		m.clicks = nil
+	}
+	return m
+}
+
+// times a tap against the nearest beat, finishing the test once there are enough
+func (m CalibrationScreen) tap() CalibrationScreen {
+	elapsed := m.elapsed()
+	if elapsed < 0 {
+		return m
+	}
+	offset := elapsed % calibrationBeat
+	if offset > calibrationBeat/2 {
+		offset -= calibrationBeat
+	}
+	m.taps = append(m.taps, offset)
+	if len(m.taps) < calibrationTaps {
+		return m
+	}
+
+	taps := slices.Clone(m.taps)
+	slices.Sort(taps)
+	median := taps[len(taps)/2]
+	*m.offset(m.selected) = min(max(int(median.Milliseconds()), -maxOffset), maxOffset)
+	m.menu.saveProfile()
+	test := "audio"
+	if m.selected == CALIBRATE_VIDEO {
+		test = "video"
+	}
+	log.Info("calibrated", "fingerprint", m.menu.profile.Fingerprint, "test", test, "offset", median)
+	when := "after"
+	if median < 0 {
+		when = "before"
+	}
+	m.message = fmt.Sprintf("Your taps landed %d ms %s the beat, the %s offset is saved", abs(median.Milliseconds()), when, test)
+	return m.stopTest()
+}
+
+func (m CalibrationScreen) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if cmd, ok := m.menu.updateShared(msg, &m.width, &m.height); ok {
+		return m, cmd
+	}
+	switch msg := msg.(type) {
+	case tea.KeyPressMsg:
+		if m.testing {
+			switch msg.String() {
+			case "space", "j", "k":
+				m = m.tap()
+			case "q", "esc":
+				m = m.stopTest()
+				m.message = "Calibration cancelled"
+			}
+			return m, nil
+		}
+
+		switch msg.String() {
+		case "down", "j":
+			m.selected = min(m.selected+1, CALIBRATE_MAX)
+		case "up", "k":
+			m.selected = max(m.selected-1, 0)
+		case "left", "h", "right", "l":
+			step := offsetStep
+			if msg.String() == "left" || msg.String() == "h" {
+				step = -step
+			}
+			offset := m.offset(m.selected)
+			*offset = min(max(*offset+step, -maxOffset), maxOffset)
+			m.menu.saveProfile()
+		case "space", "enter":
+			if m.selected == CALIBRATE_AUDIO && !m.menu.connected {
+				m.message = "Connect the audio first, the audio test plays clicks through it"
+				return m, nil
+			}
+			return m.startTest()
+		case "q", "esc":
+			return m.menu.resume()
+		}
+	case calibrationTickMsg:
+		if m.testing && msg.run == m.run {
+			return m, m.tick()
+		}
+	}
+	return m, nil
+}
+
+func (m CalibrationScreen) View() tea.View {
+	label := func(text string) string {
+		return lipgloss.NewStyle().Foreground(subtle).Width(14).Render(text)
+	}
+	value := func(i int) string {
+		style := lipgloss.NewStyle().Foreground(normal)
+		if i == m.selected {
+			style = style.Foreground(highlight).Bold(true)
+		}
+		return style.Render(fmt.Sprintf("◂ %d ms ▸", *m.offset(i)))
+	}
+
+	var details string
+	switch {
+	case m.testing && m.selected == CALIBRATE_AUDIO:
+		details = lipgloss.JoinVertical(0,
+			"Tap space along with the clicks you hear.",
+			"Keep your eyes off the screen, only the sound counts.",
+		)
+	case m.testing:
+		color := subtle
+		if m.elapsed()%calibrationBeat < calibrationFlash {
+			color = highlight
+		}
+		details = lipgloss.JoinVertical(0,
+			"Tap space as the box lights up.",
+			"",
+			lipgloss.NewStyle().Background(color).Width(20).Height(5).Render(""),
+		)
+	default:
+		details = lipgloss.JoinVertical(0,
+			label("Audio offset")+value(CALIBRATE_AUDIO),
+			label("Video offset")+value(CALIBRATE_VIDEO),
+			"",
+			lipgloss.NewStyle().Foreground(subtle).Render("The audio test plays clicks to tap along to, the video test flashes a box."),
+			lipgloss.NewStyle().Foreground(subtle).Render("Notes are judged against the audio and drawn against the video offset."),
+		)
+	}
+	if m.testing {
+		progress := strings.Repeat("●", len(m.taps)) + strings.Repeat("○", calibrationTaps-len(m.taps))
+		details = lipgloss.JoinVertical(0, details, "", lipgloss.NewStyle().Foreground(highlight).Render(progress))
+	}
+	if m.message != "" {
+		details = lipgloss.JoinVertical(0, details, "", lipgloss.NewStyle().Foreground(normal).Render(m.message))
+	}
+
+	help := "enter start test • ←/→ adjust • esc back"
+	if m.testing {
+		help = "space tap • esc cancel"
+	}
+	result := lipgloss.JoinVertical(0,
+		lipgloss.NewStyle().Foreground(highlight).Bold(true).Render("Calibration"),
+		"",
+		lipgloss.NewStyle().Foreground(normal).Border(lipgloss.NormalBorder()).BorderForeground(subtle).Padding(1, 2).Width(80).Render(details),
+		lipgloss.NewStyle().Foreground(subtle).Render(help),
+	)
+	result = lipgloss.Place(m.width, m.height, 0.5, 0.5, result)
+	view := tea.NewView(result)
+	view.KeyReleases = true
+	return view
+}
+
+func abs(x int64) int64 {
+	return max(x, -x)
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// prefix of the environment variables which override the config file
+const envPrefix = "TERMINAL_HERO_"
+
+type ServerConfig struct {
+	Host    string `toml:"host"`
+	Port    int    `toml:"port"`
+	HostKey string `toml:"host_key"`
+	// address players connect to, shown in the audio command. Defaults to the address the
+	// player's game connection came in on and the listening port
+	PublicHost string `toml:"public_host"`
+	PublicPort int    `toml:"public_port"`
+	// how long a game waits for its audio connection to pair
+	PairTimeout time.Duration `toml:"pair_timeout"`
+	// how long a session is kept after both the game and audio disconnect, so the player can
+	// reconnect to it
+	SessionGrace time.Duration `toml:"session_grace"`
+}
+
+type AuthConfig struct {
+	Mode           AuthMode `toml:"mode"`
+	AuthorizedKeys string   `toml:"authorized_keys"`
+	BannedKeys     string   `toml:"banned_keys"`
+	Guests         bool     `toml:"guests"`
+}
+
+// settings of the mixer every session gets, the output is always 16 bit little endian PCM
+type AudioConfig struct {
+	SampleRate     int     `toml:"sample_rate"`
+	Channels       int     `toml:"channels"`
+	FramesPerWrite int     `toml:"frames_per_write"`
+	MixAmp         float64 `toml:"mix_amp"`
+	// how far ahead of real time audio is sent, more survives network hiccups but delays sounds
+	Latency time.Duration `toml:"latency"`
+}
+
+type GameConfig struct {
+	// half-character position notes spawn at
+	NoteSpawn int `toml:"note_spawn"`
+	// half-characters per second notes move at for players who haven't picked a speed
+	NoteSpeed int `toml:"note_speed"`
+	// volume of the player's instrument stem after a miss until the next hit, 0 mutes it
+	MissVolume float64 `toml:"miss_volume"`
+	// the largest timing error each judgement is given for, a note later than the miss window
+	// can't be hit anymore
+	PerfectWindow time.Duration `toml:"perfect_window"`
+	GreatWindow   time.Duration `toml:"great_window"`
+	GoodWindow    time.Duration `toml:"good_window"`
+	MissWindow    time.Duration `toml:"miss_window"`
+	// score per note of each judgement before the multiplier, a miss scores nothing
+	PerfectScore float64 `toml:"perfect_score"`
+	GreatScore   float64 `toml:"great_score"`
+	GoodScore    float64 `toml:"good_score"`
+}
+
+type PathsConfig struct {
+	Songs       string `toml:"songs"`
+	Leaderboard string `toml:"leaderboard"`
+	Profiles    string `toml:"profiles"`
+	MissSound   string `toml:"miss_sound"`
+}
+
+type Config struct {
+	Server ServerConfig `toml:"server"`
+	Auth   AuthConfig   `toml:"auth"`
+	Audio  AudioConfig  `toml:"audio"`
+	Game   GameConfig   `toml:"game"`
+	Paths  PathsConfig  `toml:"paths"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Server: ServerConfig{Host: "0.0.0.0", Port: 23234, HostKey: ".ssh/id_ed25519", PairTimeout: 5 * time.Minute, SessionGrace: time.Minute},
+		Auth:   AuthConfig{Mode: AuthOpen},
+		Audio:  AudioConfig{SampleRate: 44100, Channels: 2, FramesPerWrite: 128, MixAmp: 1.0, Latency: 50 * time.Millisecond},
+		Game: GameConfig{
+			NoteSpawn:     450,
+			NoteSpeed:     200,
+			MissVolume:    0,
+			PerfectWindow: 35 * time.Millisecond,
+			GreatWindow:   70 * time.Millisecond,
+			GoodWindow:    110 * time.Millisecond,
+			MissWindow:    150 * time.Millisecond,
+			PerfectScore:  100,
+			GreatScore:    70,
+			GoodScore:     40,
+		},
+		Paths: PathsConfig{
+			Songs:       "songs",
+			Leaderboard: "leaderboard.db",
+			Profiles:    "profiles.db",
+			MissSound:   "strum2.raw",
+		},
+	}
+}
+
+// a setting which can be given as a flag or environment variable, value points into the Config
+type option struct {
+	name  string
+	usage string
+	value any
+}
+
+func (c *Config) options() []option {
+	return []option{
+		{"host", "address to listen on", &c.Server.Host},
+		{"port", "port to listen on", &c.Server.Port},
+		{"host-key", "path of the SSH host key, generated if missing", &c.Server.HostKey},
+		{"public-host", "host players connect to, shown in the audio command", &c.Server.PublicHost},
+		{"public-port", "port players connect to if it differs from -port", &c.Server.PublicPort},
+		{"pair-timeout", "how long a pairing code stays valid before audio connects", &c.Server.PairTimeout},
+		{"session-grace", "how long a session is kept for a player to reconnect", &c.Server.SessionGrace},
+		{"auth", "who may connect: open or allowlist", &c.Auth.Mode},
+		{"authorized-keys", "authorized_keys file of the keys allowed in allowlist mode", &c.Auth.AuthorizedKeys},
+		{"banned-keys", "authorized_keys style file of keys which may never connect", &c.Auth.BannedKeys},
+		{"guests", "let clients without an accepted key play as a guest", &c.Auth.Guests},
+		{"sample-rate", "sample rate of the audio stream in Hz", &c.Audio.SampleRate},
+		{"channels", "channels of the audio stream", &c.Audio.Channels},
+		{"frames-per-write", "audio frames mixed and sent at a time", &c.Audio.FramesPerWrite},
+		{"mix-amp", "volume every sound is scaled by", &c.Audio.MixAmp},
+		{"latency", "how far ahead of real time audio is sent", &c.Audio.Latency},
+		{"note-spawn", "half-character position notes spawn at", &c.Game.NoteSpawn},
+		{"note-speed", "default half-characters per second notes move at", &c.Game.NoteSpeed},
+		{"miss-volume", "volume of the instrument's stem after a miss, 0 mutes it", &c.Game.MissVolume},
+		{"perfect-window", "largest timing error a Perfect is given for", &c.Game.PerfectWindow},
+		{"great-window", "largest timing error a Great is given for", &c.Game.GreatWindow},
+		{"good-window", "largest timing error a Good is given for", &c.Game.GoodWindow},
+		{"miss-window", "how late a note can be before it is missed", &c.Game.MissWindow},
+		{"perfect-score", "score per note hit Perfect", &c.Game.PerfectScore},
+		{"great-score", "score per note hit Great", &c.Game.GreatScore},
+		{"good-score", "score per note hit Good", &c.Game.GoodScore},
+		{"songs", "directory scanned for songs", &c.Paths.Songs},
+		{"leaderboard", "path of the leaderboard database", &c.Paths.Leaderboard},
+		{"profiles", "path of the profiles database", &c.Paths.Profiles},
+		{"miss-sound", "sound played on a missed note", &c.Paths.MissSound},
+	}
+}
+
+func (o option) env() string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(o.name, "-", "_"))
+}
+
+func (o option) set(s string) error {
+	var err error
+	switch v := o.value.(type) {
+	case *string:
+		*v = s
+	case *AuthMode:
+		*v = AuthMode(s)
+	case *int:
+		*v, err = strconv.Atoi(s)
+	case *time.Duration:
+		*v, err = time.ParseDuration(s)
+	case *float64:
+		*v, err = strconv.ParseFloat(s, 64)
+	case *bool:
+		*v, err = strconv.ParseBool(s)
+	default:
+		panic(fmt.Sprintf("option %s has unsupported type %T", o.name, o.value))
+	}
+	return err
+}
+
+// builds the config from the defaults, then the config file, then environment variables, then
+// flags, each overriding the one before
+func LoadConfig(args []string) (*Config, error) {
+	config := DefaultConfig()
+	options := config.options()
+
+	fs := flag.NewFlagSet("terminal-hero", flag.ContinueOnError)
+	path := fs.String("config", os.Getenv(envPrefix+"CONFIG"), "path of a TOML config file")
+	// flags are only applied after the file and environment are read, so collect them first
+	flags := []func() error{}
+	for _, o := range options {
+		usage := fmt.Sprintf("%s (%s)", o.usage, o.env())
+		apply := func(s string) error {
+			flags = append(flags, func() error {
+				if err := o.set(s); err != nil {
+					return fmt.Errorf("flag -%s: %w", o.name, err)
+				}
+				return nil
+			})
+			return nil
+		}
+		// bool options can be given as a bare flag
+		if _, ok := o.value.(*bool); ok {
+			fs.BoolFunc(o.name, usage, apply)
+		} else {
+			fs.Func(o.name, usage, apply)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *path != "" {
+		meta, err := toml.DecodeFile(*path, &config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config: %w", err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("unknown config key %q in %s", undecoded[0].String(), *path)
+		}
+	}
+
+	for _, o := range options {
+		if s, ok := os.LookupEnv(o.env()); ok {
+			if err := o.set(s); err != nil {
+				return nil, fmt.Errorf("%s: %w", o.env(), err)
+			}
+		}
+	}
+
+	for _, apply := range flags {
+		if err := apply(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &config, config.Validate()
+}
+
+// checks every setting, returning all the problems together
+func (c *Config) Validate() error {
+	errs := []error{}
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Port > 0 && c.Server.Port < 65536, "port must be between 1 and 65535, got %d", c.Server.Port)
+	check(c.Server.PublicPort >= 0 && c.Server.PublicPort < 65536, "public port must be between 0 and 65535 (0 uses port), got %d", c.Server.PublicPort)
+	check(c.Server.HostKey != "", "host key path can't be empty")
+	check(c.Server.PairTimeout > 0, "pair timeout must be above 0, got %s", c.Server.PairTimeout)
+	check(c.Server.SessionGrace >= 0, "session grace can't be negative, got %s", c.Server.SessionGrace)
+	check(c.Auth.Mode == AuthOpen || c.Auth.Mode == AuthAllowlist, "auth must be %q or %q, got %q", AuthOpen, AuthAllowlist, c.Auth.Mode)
+	check(c.Auth.Mode != AuthAllowlist || c.Auth.AuthorizedKeys != "", "allowlist auth needs an authorized keys file")
+
+	check(c.Audio.SampleRate >= 8000 && c.Audio.SampleRate <= 192000, "sample rate must be between 8000 and 192000 Hz, got %d", c.Audio.SampleRate)
+	check(c.Audio.Channels == 1 || c.Audio.Channels == 2, "channels must be 1 or 2, got %d", c.Audio.Channels)
+	check(c.Audio.FramesPerWrite > 0 && c.Audio.FramesPerWrite <= 8192, "frames per write must be between 1 and 8192, got %d", c.Audio.FramesPerWrite)
+	check(c.Audio.MixAmp > 0 && c.Audio.MixAmp <= 4, "mix amp must be above 0 and at most 4, got %g", c.Audio.MixAmp)
+	check(c.Audio.Latency >= time.Millisecond && c.Audio.Latency <= time.Second, "latency must be between 1ms and 1s, got %s", c.Audio.Latency)
+
+	// notes need room to travel to the target
+	check(c.Game.NoteSpawn > targetPosition, "note spawn must be above %d, got %d", int(targetPosition), c.Game.NoteSpawn)
+	check(c.Game.NoteSpeed > 0, "note speed must be above 0, got %d", c.Game.NoteSpeed)
+	check(c.Game.MissVolume >= 0 && c.Game.MissVolume <= 1, "miss volume must be between 0 and 1, got %g", c.Game.MissVolume)
+	// a worse judgement has to be given for the errors too large for a better one
+	check(c.Game.PerfectWindow > 0, "perfect window must be above 0, got %s", c.Game.PerfectWindow)
+	check(c.Game.GreatWindow > c.Game.PerfectWindow, "great window must be above the perfect window, got %s", c.Game.GreatWindow)
+	check(c.Game.GoodWindow > c.Game.GreatWindow, "good window must be above the great window, got %s", c.Game.GoodWindow)
+	check(c.Game.MissWindow > c.Game.GoodWindow, "miss window must be above the good window, got %s", c.Game.MissWindow)
+	check(c.Game.PerfectScore > 0, "perfect score must be above 0, got %g", c.Game.PerfectScore)
+	check(c.Game.GreatScore >= 0 && c.Game.GreatScore <= c.Game.PerfectScore, "great score must be between 0 and the perfect score, got %g", c.Game.GreatScore)
+	check(c.Game.GoodScore >= 0 && c.Game.GoodScore <= c.Game.GreatScore, "good score must be between 0 and the great score, got %g", c.Game.GoodScore)
+
+	if info, err := os.Stat(c.Paths.Songs); err != nil {
+		errs = append(errs, fmt.Errorf("songs directory: %w", err))
+	} else {
+		check(info.IsDir(), "songs path %s is not a directory", c.Paths.Songs)
+	}
+	check(c.Paths.Leaderboard != "", "leaderboard path can't be empty")
+	check(c.Paths.Profiles != "", "profiles path can't be empty")
+	if _, err := os.Stat(c.Paths.MissSound); err != nil {
+		errs = append(errs, fmt.Errorf("miss sound: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
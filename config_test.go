@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+		want int
+	}{
+		{"default", "", nil, nil, 23234},
+		{"file", "[server]\nport = 1000\n", nil, nil, 1000},
+		{"env beats file", "[server]\nport = 1000\n", map[string]string{"TERMINAL_HERO_PORT": "2000"}, nil, 2000},
+		{"flag beats env", "[server]\nport = 1000\n", map[string]string{"TERMINAL_HERO_PORT": "2000"}, []string{"-port", "3000"}, 3000},
+		{"flag beats file", "[server]\nport = 1000\n", nil, []string{"-port=3000"}, 3000},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for key, value := range test.env {
+				t.Setenv(key, value)
+			}
+			args := test.args
+			if test.file != "" {
+				path := filepath.Join(t.TempDir(), "config.toml")
+				if err := os.WriteFile(path, []byte(test.file), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				args = append([]string{"-config", path}, args...)
+			}
+			config, err := LoadConfig(args)
+			if err != nil {
+				t.Fatalf("LoadConfig() error = %v", err)
+			}
+			if config.Server.Port != test.want {
+				t.Errorf("port = %d, want %d", config.Server.Port, test.want)
+			}
+			// settings nothing overrides keep their defaults
+			if config.Game.NoteSpeed != DefaultConfig().Game.NoteSpeed {
+				t.Errorf("note speed = %d, want the default", config.Game.NoteSpeed)
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		args []string
+		want string
+	}{
+		{"unknown key", "[server]\nprot = 1000\n", nil, "unknown config key"},
+		{"bad flag value", "", []string{"-port", "high"}, "flag -port"},
+		{"invalid setting", "", []string{"-channels", "3"}, "channels must be 1 or 2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := test.args
+			if test.file != "" {
+				path := filepath.Join(t.TempDir(), "config.toml")
+				if err := os.WriteFile(path, []byte(test.file), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				args = append([]string{"-config", path}, args...)
+			}
+			_, err := LoadConfig(args)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("LoadConfig() error = %v, want one containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		want   string
+	}{
+		{"defaults", func(c *Config) {}, ""},
+		{"public port 0 uses port", func(c *Config) { c.Server.PublicPort = 0 }, ""},
+		{"public port", func(c *Config) { c.Server.PublicPort = 2222 }, ""},
+		{"public port out of range", func(c *Config) { c.Server.PublicPort = 70000 }, "public port must be between 0 and 65535"},
+		{"port 0", func(c *Config) { c.Server.Port = 0 }, "port must be between 1 and 65535"},
+		{"allowlist without keys", func(c *Config) { c.Auth.Mode = AuthAllowlist }, "allowlist auth needs"},
+		{"windows out of order", func(c *Config) { c.Game.GoodWindow = c.Game.GreatWindow }, "good window must be above"},
+		{"missing songs", func(c *Config) { c.Paths.Songs = "no-such-songs" }, "songs directory"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := DefaultConfig()
+			test.modify(&config)
+			err := config.Validate()
+			if test.want == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want none", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("Validate() error = %v, want one containing %q", err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,549 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hajimehoshi/go-mp3"
+	"github.com/jfreymuth/oggvorbis"
+	"github.com/pion/opus"
+	"github.com/pion/opus/pkg/oggreader"
+)
+
+// .raw files have no header, so they are taken to be in the format the game has always used
+const (
+	rawSampleRate = 44100
+	rawChannels   = 2
+)
+
+// decoded audio as interleaved samples between -1 and 1
+type sampleSource interface {
+	// fills p with whole frames of samples, returning how many samples were read
+	Read(p []float32) (int, error)
+	SampleRate() int
+	Channels() int
+	// length in frames, -1 if it isn't known
+	Length() int64
+	// moves to the frame
+	SetPosition(frame int64) error
+}
+
+// integer or float PCM, read straight from a file or from the MP3 decoder
+type pcmSource struct {
+	r          io.ReadSeeker
+	sampleRate int
+	channels   int
+	// bytes per sample
+	width int
+	float bool
+	// offset of the first sample and the length of the samples, -1 if unknown
+	start  int64
+	length int64
+	buffer []byte
+}
+
+func (s *pcmSource) SampleRate() int { return s.sampleRate }
+func (s *pcmSource) Channels() int   { return s.channels }
+
+func (s *pcmSource) Length() int64 {
+	if s.length < 0 {
+		return -1
+	}
+	return s.length / int64(s.width*s.channels)
+}
+
+func (s *pcmSource) SetPosition(frame int64) error {
+	_, err := s.r.Seek(s.start+frame*int64(s.width*s.channels), io.SeekStart)
+	return err
+}
+
+func (s *pcmSource) Read(p []float32) (int, error) {
+	frameSize := s.width * s.channels
+	frames := len(p) / s.channels
+	if cap(s.buffer) < frames*frameSize {
+		s.buffer = make([]byte, frames*frameSize)
+	}
+	buffer := s.buffer[:frames*frameSize]
+	n, err := io.ReadFull(s.r, buffer)
+	// a cut off frame at the end of the file is dropped
+	n -= n % frameSize
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+
+	for i := range n / s.width {
+		sample := buffer[i*s.width:]
+		switch {
+		case s.float:
+			p[i] = math.Float32frombits(binary.LittleEndian.Uint32(sample))
+		case s.width == 1:
+			// 8 bit WAV is unsigned
+			p[i] = (float32(sample[0]) - 128) / 128
+		case s.width == 2:
+			p[i] = float32(int16(binary.LittleEndian.Uint16(sample))) / (1 << 15)
+		case s.width == 3:
+			p[i] = float32(int32(uint32(sample[0])<<8|uint32(sample[1])<<16|uint32(sample[2])<<24)>>8) / (1 << 23)
+		case s.width == 4:
+			p[i] = float32(int32(binary.LittleEndian.Uint32(sample))) / (1 << 31)
+		}
+	}
+	return n / s.width, err
+}
+
+// Ogg Vorbis, which the decoder already gives as floats
+type vorbisSource struct {
+	*oggvorbis.Reader
+}
+
+func (s vorbisSource) Length() int64 {
+	// the reader reports 0 when it can't work the length out
+	if s.Reader.Length() == 0 {
+		return -1
+	}
+	return s.Reader.Length()
+}
+
+const (
+	// Opus always decodes at 48 kHz, and a packet holds at most 120 ms of it
+	opusSampleRate = 48000
+	opusMaxPacket  = 5760
+	// audio decoded before a seek target and thrown away, so the decoder has settled by then
+	opusPreRoll = 3840
+)
+
+// Ogg Opus, decoded a packet at a time
+type opusSource struct {
+	file     *os.File
+	ogg      *oggreader.OggReader
+	decoder  opus.Decoder
+	channels int
+	// frames at the start that are only there to prime the decoder
+	preSkip int64
+	gain    float32
+	length  int64
+	// the last decoded packet, and the part of it not read yet
+	decoded []float32
+	pending []float32
+	// frame the next packet starts on counting the pre-skip, and the frame reading starts from
+	position int64
+	seekTo   int64
+}
+
+func openOpus(f *os.File) (sampleSource, error) {
+	ogg, header, err := oggreader.NewWith(f)
+	if err != nil {
+		return nil, err
+	}
+	// mapping family 0 is mono or stereo, the others are multistream surround
+	if header.ChannelMap != 0 || header.Channels < 1 || header.Channels > 2 {
+		return nil, fmt.Errorf("unsupported Opus channel mapping %d with %d channels", header.ChannelMap, header.Channels)
+	}
+	decoder, err := opus.NewDecoderWithOutput(opusSampleRate, int(header.Channels))
+	if err != nil {
+		return nil, err
+	}
+	source := &opusSource{
+		file:     f,
+		ogg:      ogg,
+		decoder:  decoder,
+		channels: int(header.Channels),
+		preSkip:  int64(header.PreSkip),
+		// the output gain is in 1/256 dB
+		gain:    float32(math.Pow(10, float64(int16(header.OutputGain))/(20*256))),
+		length:  -1,
+		decoded: make([]float32, opusMaxPacket*int(header.Channels)),
+		seekTo:  int64(header.PreSkip),
+	}
+	if granule, err := lastGranule(f); err == nil && int64(granule) > source.preSkip {
+		source.length = int64(granule) - source.preSkip
+	}
+	if err := source.SetPosition(0); err != nil {
+		return nil, err
+	}
+	return source, nil
+}
+
+// granule position of the last Ogg page, which for Opus is where the audio ends
+func lastGranule(f *os.File) (uint64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	tail := make([]byte, min(info.Size(), 64*1024))
+	if _, err := f.ReadAt(tail, info.Size()-int64(len(tail))); err != nil {
+		return 0, err
+	}
+	i := bytes.LastIndex(tail, []byte("OggS\x00"))
+	if i < 0 || len(tail)-i < 14 {
+		return 0, errors.New("no Ogg page at the end of the file")
+	}
+	return binary.LittleEndian.Uint64(tail[i+6:]), nil
+}
+
+// frames in a packet, from its table of contents byte
+func opusPacketFrames(packet []byte) int64 {
+	if len(packet) == 0 {
+		return 0
+	}
+	config := packet[0] >> 3
+	var frame int64
+	switch {
+	case config < 12:
+		// SILK, 10, 20, 40 or 60 ms
+		frame = []int64{480, 960, 1920, 2880}[config%4]
+	case config < 16:
+		// hybrid, 10 or 20 ms
+		frame = []int64{480, 960}[config%2]
+	default:
+		// CELT, 2.5, 5, 10 or 20 ms
+		frame = []int64{120, 240, 480, 960}[config%4]
+	}
+	switch packet[0] & 3 {
+	case 0:
+		return frame
+	case 1, 2:
+		return 2 * frame
+	}
+	if len(packet) < 2 {
+		return 0
+	}
+	return frame * int64(packet[1]&0x3f)
+}
+
+func (s *opusSource) SampleRate() int { return opusSampleRate }
+func (s *opusSource) Channels() int   { return s.channels }
+func (s *opusSource) Length() int64   { return s.length }
+
+// Ogg has no index, so seeking reads from the start again, only decoding the packets near the
+// frame
+func (s *opusSource) SetPosition(frame int64) error {
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	ogg, _, err := oggreader.NewWith(s.file)
+	if err != nil {
+		return err
+	}
+	if err := s.decoder.Init(opusSampleRate, s.channels); err != nil {
+		return err
+	}
+	s.ogg = ogg
+	s.pending = nil
+	s.position = 0
+	s.seekTo = s.preSkip + frame
+	return nil
+}
+
+func (s *opusSource) Read(p []float32) (int, error) {
+	for len(s.pending) == 0 {
+		packet, _, err := s.ogg.ParseNextPacket()
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		if err != nil {
+			return 0, err
+		}
+		if bytes.HasPrefix(packet, []byte("OpusTags")) {
+			continue
+		}
+
+		start := s.position
+		s.position += opusPacketFrames(packet)
+		if s.position <= s.seekTo-opusPreRoll {
+			continue
+		}
+		n, err := s.decoder.DecodeToFloat32(packet, s.decoded)
+		if err != nil {
+			return 0, fmt.Errorf("failed to decode Opus packet: %w", err)
+		}
+		skip := min(max(s.seekTo-start, 0), int64(n))
+		end := int64(n)
+		// the last page's granule cuts off the padding at the end
+		if s.length >= 0 {
+			end = min(end, max(s.preSkip+s.length-start, skip))
+		}
+		s.pending = s.decoded[skip*int64(s.channels) : end*int64(s.channels)]
+	}
+
+	n := copy(p[:len(p)-len(p)%s.channels], s.pending)
+	s.pending = s.pending[n:]
+	for i := range n {
+		p[i] *= s.gain
+	}
+	return n, nil
+}
+
+func openWAV(f *os.File) (sampleSource, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return nil, err
+	}
+
+	source := &pcmSource{r: f, length: -1}
+	foundFormat := false
+	for {
+		chunk := make([]byte, 8)
+		if _, err := io.ReadFull(f, chunk); err != nil {
+			return nil, fmt.Errorf("WAV file has no data chunk: %w", err)
+		}
+		id := string(chunk[:4])
+		size := int64(binary.LittleEndian.Uint32(chunk[4:]))
+
+		switch id {
+		case "fmt ":
+			format := make([]byte, size)
+			if _, err := io.ReadFull(f, format); err != nil || size < 16 {
+				return nil, fmt.Errorf("WAV file has a broken fmt chunk")
+			}
+			tag := binary.LittleEndian.Uint16(format)
+			// WAVE_FORMAT_EXTENSIBLE keeps the real format in its sub format GUID
+			if tag == 0xfffe && size >= 26 {
+				tag = binary.LittleEndian.Uint16(format[24:])
+			}
+			source.channels = int(binary.LittleEndian.Uint16(format[2:]))
+			source.sampleRate = int(binary.LittleEndian.Uint32(format[4:]))
+			bits := int(binary.LittleEndian.Uint16(format[14:]))
+			source.width = bits / 8
+			source.float = tag == 3
+			if (tag != 1 && tag != 3) || (source.float && bits != 32) || bits%8 != 0 || source.width < 1 || source.width > 4 {
+				return nil, fmt.Errorf("unsupported WAV format %d with %d bit samples", tag, bits)
+			}
+			if source.channels < 1 || source.sampleRate < 1 {
+				return nil, fmt.Errorf("WAV file has %d channels at %d Hz", source.channels, source.sampleRate)
+			}
+			foundFormat = true
+		case "data":
+			if !foundFormat {
+				return nil, fmt.Errorf("WAV file has data before its format")
+			}
+			start, err := f.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return nil, err
+			}
+			source.start = start
+			// streamed WAV files leave the length at its largest
+			if size != 0xffffffff {
+				source.length = size
+			}
+			return source, nil
+		default:
+			if _, err := f.Seek(size+size%2, io.SeekCurrent); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// opens an audio file as samples, working out the format from its extension for raw files and
+// from its first bytes for the rest
+func openSampleSource(f *os.File) (sampleSource, error) {
+	// raw samples can look like anything, such as an MP3 frame sync
+	switch strings.ToLower(filepath.Ext(f.Name())) {
+	case ".raw", ".pcm":
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		return &pcmSource{r: f, sampleRate: rawSampleRate, channels: rawChannels, width: 2, length: info.Size()}, nil
+	}
+
+	magic := make([]byte, 64)
+	n, err := io.ReadFull(f, magic)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	magic = magic[:n]
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(magic) >= 12 && string(magic[:4]) == "RIFF" && string(magic[8:12]) == "WAVE":
+		return openWAV(f)
+	case bytes.HasPrefix(magic, []byte("OggS")) && bytes.Contains(magic, []byte("OpusHead")):
+		source, err := openOpus(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Ogg Opus: %w", err)
+		}
+		return source, nil
+	case bytes.HasPrefix(magic, []byte("OggS")):
+		reader, err := oggvorbis.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Ogg Vorbis: %w", err)
+		}
+		return vorbisSource{reader}, nil
+	case bytes.HasPrefix(magic, []byte("fLaC")):
+		return nil, errors.New("FLAC audio is not supported yet, convert it to Ogg Vorbis, Opus, MP3 or WAV")
+	case bytes.HasPrefix(magic, []byte("ID3")) || (len(magic) >= 2 && magic[0] == 0xff && magic[1]&0xe0 == 0xe0):
+		decoder, err := mp3.NewDecoder(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read MP3: %w", err)
+		}
+		// the decoder always gives 16 bit stereo
+		return &pcmSource{r: decoder, sampleRate: decoder.SampleRate(), channels: 2, width: 2, length: decoder.Length()}, nil
+	}
+
+	return nil, fmt.Errorf("unknown audio format")
+}
+
+// turns a sample source into 16 bit PCM in the mixer's format, mapping the channels and
+// resampling with linear interpolation
+type decodedAudio struct {
+	file   *os.File
+	source sampleSource
+	format AudioFormat
+	// source frames per output frame
+	step float64
+	// the two source frames output is interpolated between, and how far between them it is
+	current []float32
+	next    []float32
+	offset  float64
+	ended   bool
+	// what current and next point into, swapped as the output moves on
+	frames [2][]float32
+	// decoded samples not turned into frames yet
+	samples []float32
+	pending []float32
+}
+
+// opens an audio file decoded to the format
+func openAudio(path string, format AudioFormat) (*decodedAudio, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audio file: %w", err)
+	}
+	source, err := openSampleSource(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	audio := &decodedAudio{
+		file:    f,
+		source:  source,
+		format:  format,
+		step:    float64(source.SampleRate()) / float64(format.SampleRate),
+		samples: make([]float32, 4096*source.Channels()),
+		frames:  [2][]float32{make([]float32, format.Channels), make([]float32, format.Channels)},
+	}
+	audio.reset()
+	return audio, nil
+}
+
+func (a *decodedAudio) Close() error {
+	return a.file.Close()
+}
+
+// length in output frames, -1 if it isn't known
+func (a *decodedAudio) Length() int64 {
+	length := a.source.Length()
+	if length < 0 {
+		return -1
+	}
+	return int64(float64(length) / a.step)
+}
+
+// moves to the output frame
+func (a *decodedAudio) SetPosition(frame int64) error {
+	if frame == 0 {
+		return nil
+	}
+	if err := a.source.SetPosition(int64(float64(frame) * a.step)); err != nil {
+		return err
+	}
+	a.reset()
+	return nil
+}
+
+func (a *decodedAudio) reset() {
+	a.current = nil
+	a.next = nil
+	a.offset = 0
+	a.ended = false
+	a.pending = nil
+}
+
+// reads the next source frame mapped to the output channels into out, nil at the end
+func (a *decodedAudio) readFrame(out []float32) ([]float32, error) {
+	channels := a.source.Channels()
+	if len(a.pending) < channels {
+		if a.ended {
+			return nil, nil
+		}
+		n, err := a.source.Read(a.samples)
+		a.pending = a.samples[:n]
+		if err == io.EOF {
+			a.ended = true
+		} else if err != nil {
+			return nil, err
+		}
+		if len(a.pending) < channels {
+			return a.readFrame(out)
+		}
+	}
+
+	in := a.pending[:channels]
+	a.pending = a.pending[channels:]
+	if a.format.Channels == 1 {
+		out[0] = 0
+		for _, sample := range in {
+			out[0] += sample / float32(channels)
+		}
+	} else {
+		// mono is copied to every channel, surround is cut down to the front channels
+		for i := range out {
+			out[i] = in[i%channels]
+		}
+	}
+	return out, nil
+}
+
+func (a *decodedAudio) Read(p []byte) (int, error) {
+	if a.current == nil {
+		var err error
+		if a.current, err = a.readFrame(a.frames[0]); err != nil {
+			return 0, err
+		}
+		if a.next, err = a.readFrame(a.frames[1]); err != nil {
+			return 0, err
+		}
+	}
+
+	frameSize := a.format.Channels * 2
+	n := 0
+	for n+frameSize <= len(p) {
+		if a.current == nil {
+			if n == 0 {
+				return 0, io.EOF
+			}
+			break
+		}
+		for i, sample := range a.current {
+			if a.next != nil {
+				sample += (a.next[i] - sample) * float32(a.offset)
+			}
+			value := int16(max(math.MinInt16, min(math.MaxInt16, sample*(1<<15))))
+			binary.LittleEndian.PutUint16(p[n+i*2:], uint16(value))
+		}
+		n += frameSize
+
+		a.offset += a.step
+		for a.offset >= 1 && a.current != nil {
+			a.offset--
+			// the frame left behind is read over with the one after next
+			free := a.current
+			a.current = a.next
+			var err error
+			if a.next, err = a.readFrame(free); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
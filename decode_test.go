@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writes 16 bit PCM samples to a WAV file in a temporary directory
+func writeWAV(t *testing.T, sampleRate int, channels int, samples []int16) string {
+	t.Helper()
+	data := make([]byte, 0, len(samples)*2)
+	for _, sample := range samples {
+		data = binary.LittleEndian.AppendUint16(data, uint16(sample))
+	}
+
+	header := []byte("RIFF")
+	header = binary.LittleEndian.AppendUint32(header, uint32(36+len(data)))
+	header = append(header, "WAVEfmt "...)
+	header = binary.LittleEndian.AppendUint32(header, 16)
+	header = binary.LittleEndian.AppendUint16(header, 1)
+	header = binary.LittleEndian.AppendUint16(header, uint16(channels))
+	header = binary.LittleEndian.AppendUint32(header, uint32(sampleRate))
+	header = binary.LittleEndian.AppendUint32(header, uint32(sampleRate*channels*2))
+	header = binary.LittleEndian.AppendUint16(header, uint16(channels*2))
+	header = binary.LittleEndian.AppendUint16(header, 16)
+	header = append(header, "data"...)
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(data)))
+
+	path := filepath.Join(t.TempDir(), "song.wav")
+	if err := os.WriteFile(path, append(header, data...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// decodes the whole file into frames of the format's channels
+func decodeAll(t *testing.T, path string, format AudioFormat) (*decodedAudio, [][]int16) {
+	t.Helper()
+	audio, err := openAudio(path, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { audio.Close() })
+
+	// an odd buffer size so reads end in the middle of the source frames
+	buffer := make([]byte, 37*format.Channels*2)
+	frames := [][]int16{}
+	for {
+		n, err := audio.Read(buffer)
+		for i := 0; i < n; i += format.Channels * 2 {
+			frame := make([]int16, format.Channels)
+			for channel := range frame {
+				frame[channel] = int16(binary.LittleEndian.Uint16(buffer[i+channel*2:]))
+			}
+			frames = append(frames, frame)
+		}
+		if err == io.EOF {
+			return audio, frames
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDecodeResampleLength(t *testing.T) {
+	tests := []struct {
+		name       string
+		sampleRate int
+		frames     int
+	}{
+		{"same rate", 44100, 1000},
+		{"upsampled", 22050, 1000},
+		{"downsampled", 48000, 4800},
+	}
+	format := AudioFormat{SampleRate: 44100, Channels: 2, BitsPerSample: 16}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeWAV(t, test.sampleRate, 2, make([]int16, test.frames*2))
+			audio, frames := decodeAll(t, path, format)
+
+			want := int64(test.frames) * int64(format.SampleRate) / int64(test.sampleRate)
+			if audio.Length() != want {
+				t.Errorf("Length() = %d, want %d", audio.Length(), want)
+			}
+			// the last output frame can land either side of the end of the source
+			if diff := int64(len(frames)) - want; diff < -1 || diff > 1 {
+				t.Errorf("decoded %d frames, want %d", len(frames), want)
+			}
+		})
+	}
+}
+
+func TestDecodeResampleInterpolates(t *testing.T) {
+	path := writeWAV(t, 22050, 1, []int16{0, 1000, 2000})
+	_, frames := decodeAll(t, path, AudioFormat{SampleRate: 44100, Channels: 1, BitsPerSample: 16})
+	want := []int16{0, 500, 1000, 1500, 2000, 2000}
+	if len(frames) != len(want) {
+		t.Fatalf("decoded %v, want %v", frames, want)
+	}
+	for i := range want {
+		// float rounding can be a step off
+		if diff := int(frames[i][0]) - int(want[i]); diff < -1 || diff > 1 {
+			t.Errorf("frame %d = %d, want %d", i, frames[i][0], want[i])
+		}
+	}
+}
+
+func TestDecodeChannelMapping(t *testing.T) {
+	tests := []struct {
+		name     string
+		channels int
+		samples  []int16
+		output   int
+		want     []int16
+	}{
+		{"mono to stereo", 1, []int16{1000}, 2, []int16{1000, 1000}},
+		{"stereo to mono", 2, []int16{1000, 3000}, 1, []int16{2000}},
+		{"stereo to stereo", 2, []int16{1000, -3000}, 2, []int16{1000, -3000}},
+		{"surround to stereo", 6, []int16{1000, -3000, 5, 6, 7, 8}, 2, []int16{1000, -3000}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeWAV(t, 44100, test.channels, test.samples)
+			_, frames := decodeAll(t, path, AudioFormat{SampleRate: 44100, Channels: test.output, BitsPerSample: 16})
+			if len(frames) != 1 {
+				t.Fatalf("decoded %d frames, want 1", len(frames))
+			}
+			for i := range test.want {
+				if diff := int(frames[0][i]) - int(test.want[i]); diff < -1 || diff > 1 {
+					t.Errorf("channel %d = %d, want %d", i, frames[0][i], test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeSetPosition(t *testing.T) {
+	samples := make([]int16, 100)
+	for i := range samples {
+		samples[i] = int16(i * 100)
+	}
+	path := writeWAV(t, 44100, 1, samples)
+	audio, err := openAudio(path, AudioFormat{SampleRate: 44100, Channels: 1, BitsPerSample: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audio.Close()
+	if err := audio.SetPosition(50); err != nil {
+		t.Fatal(err)
+	}
+	buffer := make([]byte, 2)
+	if _, err := audio.Read(buffer); err != nil {
+		t.Fatal(err)
+	}
+	if got := int16(binary.LittleEndian.Uint16(buffer)); got != 5000 {
+		t.Errorf("first sample after SetPosition(50) = %d, want 5000", got)
+	}
+}
+
+func TestOpenSampleSourceRejectsUnknownFormats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "song.ogg")
+	if err := os.WriteFile(path, []byte("not audio at all"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openAudio(path, AudioFormat{SampleRate: 44100, Channels: 2, BitsPerSample: 16}); err == nil {
+		t.Error("openAudio() of a file which isn't audio succeeded")
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea/v2"
+	"github.com/charmbracelet/lipgloss/v2"
+	"github.com/charmbracelet/log"
+	gotar_hero "github.com/mbund/terminal-hero/pkg/gotar-hero"
+)
+
+// notes per second which fill the whole density bar
+const maxDensity = 10.0
+
+type TrackSelect struct {
+	width  int
+	height int
+	// the song select to go back to
+	songSelect SongSelect
+	song       Song
+	selected   int
+}
+
+func NewTrackSelect(songSelect SongSelect, song Song) TrackSelect {
+	m := TrackSelect{width: songSelect.width, height: songSelect.height, songSelect: songSelect, song: song}
+	// start on the track the player picked last time
+	for i, track := range song.Chart.Tracks {
+		if track.Name == songSelect.menu.profile.Settings.Track {
+			m.selected = i
+		}
+	}
+	return m
+}
+
+func (m TrackSelect) Init() tea.Cmd {
+	return nil
+}
+
+func (m TrackSelect) tracks() []gotar_hero.InstrumentTrack {
+	return m.song.Chart.Tracks
+}
+
+func (m TrackSelect) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if cmd, ok := m.songSelect.menu.updateShared(msg, &m.width, &m.height); ok {
+		m.songSelect.width = m.width
+		m.songSelect.height = m.height
+		return m, cmd
+	}
+	switch msg := msg.(type) {
+	case tea.KeyPressMsg:
+		switch msg.String() {
+		case "down", "j":
+			m.selected = min(m.selected+1, max(0, len(m.tracks())-1))
+		case "up", "k":
+			m.selected = max(m.selected-1, 0)
+		case "q", "esc":
+			return m.songSelect.resume()
+		case "space", "enter":
+			if m.selected >= len(m.tracks()) {
+				return m, nil
+			}
+			m.songSelect.menu.profile.Settings.Track = m.tracks()[m.selected].Name
+			m.songSelect.menu.saveProfile()
+			game, err := NewGame(m.songSelect.menu, m.song, m.tracks()[m.selected].Name)
+			if err != nil {
+				log.Error("failed to start game", "error", err)
+				return m, nil
+			}
+			return game, game.Init()
+		}
+	}
+	return m, nil
+}
+
+// notes per second over the whole song
+func (m TrackSelect) density(track gotar_hero.InstrumentTrack) float64 {
+	if m.song.Length == 0 {
+		return 0
+	}
+	return float64(track.PlayableNotes()) / m.song.Length
+}
+
+func (m TrackSelect) View() tea.View {
+	tracks := m.tracks()
+	list := ""
+	for i, track := range tracks {
+		// a header above the first track of each instrument
+		if i == 0 || tracks[i-1].Instrument != track.Instrument {
+			if i != 0 {
+				list += "\n"
+			}
+			list = lipgloss.JoinVertical(0, list, lipgloss.NewStyle().Foreground(normal).Bold(true).Render(track.Instrument.String()))
+		}
+
+		density := m.density(track)
+		filled := min(20, int(density/maxDensity*20))
+		bar := strings.Repeat("█", filled) + strings.Repeat("░", 20-filled)
+
+		name := track.Difficulty.String()
+		if track.Instrument == gotar_hero.InstrumentUnknown {
+			name = track.Name
+		}
+		line := fmt.Sprintf("%-8s %5d notes %5.1f/s ", name, track.PlayableNotes(), density)
+		style := lipgloss.NewStyle().Foreground(subtle)
+		if i == m.selected {
+			style = style.Foreground(highlight).Bold(true)
+			line = "▸ " + line
+		} else {
+			line = "  " + line
+		}
+		list = lipgloss.JoinVertical(0, list, style.Render(line)+lipgloss.NewStyle().Foreground(highlight).Render(bar))
+	}
+	if len(tracks) == 0 {
+		list = lipgloss.NewStyle().Foreground(subtle).Render("This chart has no tracks")
+	}
+
+	title := lipgloss.NewStyle().Foreground(highlight).Bold(true).Render(m.song.Title)
+	if m.song.Artist != "" {
+		title += lipgloss.NewStyle().Foreground(subtle).Render(" by " + m.song.Artist)
+	}
+
+	result := lipgloss.JoinVertical(0,
+		title,
+		"",
+		lipgloss.NewStyle().Border(lipgloss.NormalBorder()).BorderForeground(subtle).Padding(1, 2).Render(list),
+		lipgloss.NewStyle().Foreground(subtle).Render("enter play • esc back"),
+	)
+	result = lipgloss.Place(m.width, m.height, 0.5, 0.5, result)
+	view := tea.NewView(result)
+	view.KeyReleases = true
+	return view
+}
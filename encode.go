@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"strings"
+
+	"github.com/mewkiz/flac"
+	"github.com/mewkiz/flac/frame"
+	"github.com/mewkiz/flac/meta"
+)
+
+// a way the mixer's 16 bit PCM can be sent to the audio session
+type AudioEncoding struct {
+	Name        string
+	Description string
+	// wraps w so PCM written to it comes out encoded
+	encoder func(w io.Writer, format AudioFormat) (io.Writer, error)
+}
+
+var AudioEncodings = []AudioEncoding{
+	{"raw", "16 bit PCM without a header, about 1.4 Mbit/s", func(w io.Writer, format AudioFormat) (io.Writer, error) {
+		return w, nil
+	}},
+	{"wav", "16 bit PCM after a WAV header, so players detect the format", newWAVEncoder},
+	{"mulaw", "8 bit G.711 µ-law, half the bandwidth of raw", newMulawEncoder},
+	{"flac", "lossless FLAC, usually around half the bandwidth of raw", newFLACEncoder},
+	{"ogg", "lossless FLAC in Ogg pages, for players which expect an Ogg stream", newOggFLACEncoder},
+}
+
+// encodings players ask for which this server can't produce, with the reason why. Opus would
+// save the most bandwidth, but the only Go Opus encoders wrap libopus through cgo, which would
+// keep the server from building as a plain Go binary, so it is left out until there is a pure Go
+// one.
+var unsupportedEncodings = map[string]string{
+	"opus": "opus is not supported, this server has no Opus encoder, try ogg, flac or mulaw",
+}
+
+func audioEncodingIndex(name string) int {
+	for i, encoding := range AudioEncodings {
+		if encoding.Name == name {
+			return i
+		}
+	}
+	return 0
+}
+
+func FindAudioEncoding(name string) (AudioEncoding, error) {
+	name = strings.ToLower(name)
+	for _, encoding := range AudioEncodings {
+		if encoding.Name == name {
+			return encoding, nil
+		}
+	}
+	if reason, ok := unsupportedEncodings[name]; ok {
+		return AudioEncoding{}, fmt.Errorf("%s", reason)
+	}
+	names := []string{}
+	for _, encoding := range AudioEncodings {
+		names = append(names, encoding.Name)
+	}
+	return AudioEncoding{}, fmt.Errorf("unknown format %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+// writes a WAV header with the largest length there is, since the stream has no end
+func newWAVEncoder(w io.Writer, format AudioFormat) (io.Writer, error) {
+	const unknownLength = 0xffffffff
+	blockAlign := format.Channels * format.BitsPerSample / 8
+
+	header := make([]byte, 0, 44)
+	header = append(header, "RIFF"...)
+	header = binary.LittleEndian.AppendUint32(header, unknownLength)
+	header = append(header, "WAVEfmt "...)
+	header = binary.LittleEndian.AppendUint32(header, 16)
+	// uncompressed PCM
+	header = binary.LittleEndian.AppendUint16(header, 1)
+	header = binary.LittleEndian.AppendUint16(header, uint16(format.Channels))
+	header = binary.LittleEndian.AppendUint32(header, uint32(format.SampleRate))
+	header = binary.LittleEndian.AppendUint32(header, uint32(format.SampleRate*blockAlign))
+	header = binary.LittleEndian.AppendUint16(header, uint16(blockAlign))
+	header = binary.LittleEndian.AppendUint16(header, uint16(format.BitsPerSample))
+	header = append(header, "data"...)
+	header = binary.LittleEndian.AppendUint32(header, unknownLength)
+
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+type mulawEncoder struct {
+	w      io.Writer
+	buffer []byte
+}
+
+func newMulawEncoder(w io.Writer, format AudioFormat) (io.Writer, error) {
+	return &mulawEncoder{w: w}, nil
+}
+
+func (e *mulawEncoder) Write(p []byte) (int, error) {
+	e.buffer = e.buffer[:0]
+	for i := 0; i+1 < len(p); i += 2 {
+		e.buffer = append(e.buffer, mulaw(int16(binary.LittleEndian.Uint16(p[i:]))))
+	}
+	if _, err := e.w.Write(e.buffer); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// encodes a sample as G.711 µ-law
+func mulaw(sample int16) byte {
+	const bias = 0x84
+	const clip = 32635
+
+	sign := byte(0)
+	value := int(sample)
+	if value < 0 {
+		sign = 0x80
+		value = -value
+	}
+	value = min(value, clip) + bias
+
+	exponent := 7
+	for mask := 0x4000; value&mask == 0 && exponent > 0; mask >>= 1 {
+		exponent--
+	}
+	mantissa := (value >> (exponent + 3)) & 0x0f
+	return ^(sign | byte(exponent<<4) | byte(mantissa))
+}
+
+// frames each FLAC frame holds, small enough that buffering them adds little latency
+const flacBlockSize = 576
+
+type flacEncoder struct {
+	encoder  *flac.Encoder
+	format   AudioFormat
+	channels frame.Channels
+	// samples of each channel waiting for a full block
+	pending [][]int32
+	// called after each frame is written with the samples of each channel in it, if set
+	frameWritten func(samples int) error
+}
+
+// hides Close from the FLAC encoder, which would otherwise close the session under it
+type writeOnly struct{ io.Writer }
+
+func newFLACEncoder(w io.Writer, format AudioFormat) (io.Writer, error) {
+	return startFLAC(writeOnly{w}, format)
+}
+
+// writes the FLAC stream header, with any metadata blocks after STREAMINFO
+func startFLAC(w io.Writer, format AudioFormat, blocks ...*meta.Block) (*flacEncoder, error) {
+	channels := frame.ChannelsMono
+	if format.Channels == 2 {
+		channels = frame.ChannelsLR
+	}
+	info := &meta.StreamInfo{
+		BlockSizeMin:  flacBlockSize,
+		BlockSizeMax:  flacBlockSize,
+		SampleRate:    uint32(format.SampleRate),
+		NChannels:     uint8(format.Channels),
+		BitsPerSample: uint8(format.BitsPerSample),
+	}
+	encoder, err := flac.NewEncoder(w, info, blocks...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start FLAC stream: %w", err)
+	}
+	return &flacEncoder{encoder: encoder, format: format, channels: channels, pending: make([][]int32, format.Channels)}, nil
+}
+
+func (e *flacEncoder) Write(p []byte) (int, error) {
+	frameSize := e.format.Channels * 2
+	for i := 0; i+frameSize <= len(p); i += frameSize {
+		for channel := range e.pending {
+			sample := int16(binary.LittleEndian.Uint16(p[i+channel*2:]))
+			e.pending[channel] = append(e.pending[channel], int32(sample))
+		}
+		if len(e.pending[0]) == flacBlockSize {
+			if err := e.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(p), nil
+}
+
+func (e *flacEncoder) flush() error {
+	subframes := make([]*frame.Subframe, len(e.pending))
+	for channel, samples := range e.pending {
+		subframes[channel] = &frame.Subframe{
+			// the encoder picks a better predictor for verbatim subframes
+			SubHeader: frame.SubHeader{Pred: frame.PredVerbatim},
+			Samples:   samples,
+			NSamples:  len(samples),
+		}
+	}
+	f := &frame.Frame{
+		Header: frame.Header{
+			HasFixedBlockSize: true,
+			BlockSize:         uint16(len(e.pending[0])),
+			SampleRate:        uint32(e.format.SampleRate),
+			Channels:          e.channels,
+			BitsPerSample:     uint8(e.format.BitsPerSample),
+		},
+		Subframes: subframes,
+	}
+	if err := e.encoder.WriteFrame(f); err != nil {
+		return err
+	}
+	if e.frameWritten != nil {
+		if err := e.frameWritten(len(e.pending[0])); err != nil {
+			return err
+		}
+	}
+	for channel := range e.pending {
+		e.pending[channel] = e.pending[channel][:0]
+	}
+	return nil
+}
+
+const (
+	// header type flag of the first page of a stream
+	oggBeginStream = 0x02
+	// segments of at most 255 bytes one page holds, which bounds the packets a page fits
+	oggMaxSegments = 255
+)
+
+// CRC-32 of Ogg pages, polynomial 0x04c11db7 without reflection
+var oggCRCTable = func() (table [256]uint32) {
+	for i := range table {
+		crc := uint32(i) << 24
+		for range 8 {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+func oggCRC(page []byte) uint32 {
+	crc := uint32(0)
+	for _, b := range page {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+// wraps the FLAC stream in Ogg pages following the Ogg FLAC mapping, one packet to a page
+type oggFLACEncoder struct {
+	w io.Writer
+	// what the FLAC encoder wrote since the last page
+	packet   bytes.Buffer
+	serial   uint32
+	sequence uint32
+	// samples of each channel in the pages so far
+	granule uint64
+}
+
+func newOggFLACEncoder(w io.Writer, format AudioFormat) (io.Writer, error) {
+	ogg := &oggFLACEncoder{w: w, serial: rand.Uint32()}
+	// the mapping asks for a VORBIS_COMMENT block after STREAMINFO
+	comment := &meta.VorbisComment{Vendor: "terminal-hero"}
+	block := &meta.Block{
+		Header: meta.Header{Type: meta.TypeVorbisComment, Length: int64(4 + len(comment.Vendor) + 4)},
+		Body:   comment,
+	}
+	encoder, err := startFLAC(&ogg.packet, format, block)
+	if err != nil {
+		return nil, err
+	}
+
+	// the first packet has the mapping's header, the signature and STREAMINFO, the second the
+	// rest of the metadata
+	const streamInfoEnd = 4 + 4 + 34
+	header := ogg.packet.Bytes()
+	first := []byte{0x7f, 'F', 'L', 'A', 'C', 1, 0}
+	// header packets after the first
+	first = binary.BigEndian.AppendUint16(first, 1)
+	first = append(first, header[:streamInfoEnd]...)
+	if err := ogg.writePage(first, oggBeginStream); err != nil {
+		return nil, err
+	}
+	if err := ogg.writePage(header[streamInfoEnd:], 0); err != nil {
+		return nil, err
+	}
+	ogg.packet.Reset()
+
+	encoder.frameWritten = func(samples int) error {
+		ogg.granule += uint64(samples)
+		err := ogg.writePage(ogg.packet.Bytes(), 0)
+		ogg.packet.Reset()
+		return err
+	}
+	return encoder, nil
+}
+
+func (e *oggFLACEncoder) writePage(packet []byte, flags byte) error {
+	segments := len(packet)/255 + 1
+	if segments > oggMaxSegments {
+		return fmt.Errorf("FLAC frame of %d bytes doesn't fit in an Ogg page", len(packet))
+	}
+	page := make([]byte, 0, 27+segments+len(packet))
+	page = append(page, "OggS"...)
+	// version
+	page = append(page, 0, flags)
+	page = binary.LittleEndian.AppendUint64(page, e.granule)
+	page = binary.LittleEndian.AppendUint32(page, e.serial)
+	page = binary.LittleEndian.AppendUint32(page, e.sequence)
+	// CRC, filled in once the page is complete
+	page = binary.LittleEndian.AppendUint32(page, 0)
+	page = append(page, byte(segments))
+	for range segments - 1 {
+		page = append(page, 255)
+	}
+	// a last segment shorter than 255 bytes ends the packet, even if it is empty
+	page = append(page, byte(len(packet)%255))
+	page = append(page, packet...)
+	binary.LittleEndian.PutUint32(page[22:], oggCRC(page))
+
+	e.sequence++
+	_, err := e.w.Write(page)
+	return err
+}
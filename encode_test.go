@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mewkiz/flac"
+)
+
+// decodes G.711 µ-law, the way players of the stream do
+func mulawDecode(b byte) int16 {
+	b = ^b
+	exponent := int(b>>4) & 0x07
+	mantissa := int(b & 0x0f)
+	value := ((mantissa << 3) + 0x84) << exponent
+	value -= 0x84
+	if b&0x80 != 0 {
+		return int16(-value)
+	}
+	return int16(value)
+}
+
+func TestMulawRoundTrip(t *testing.T) {
+	for sample := int64(math.MinInt16); sample <= math.MaxInt16; sample += 7 {
+		decoded := int64(mulawDecode(mulaw(int16(sample))))
+		// µ-law keeps about 13 bits, so the error grows with the size of the sample
+		tolerance := max(8, abs(sample)/16)
+		if abs(decoded-sample) > tolerance {
+			t.Fatalf("mulaw(%d) decodes to %d, want within %d", sample, decoded, tolerance)
+		}
+	}
+	if mulaw(0) != 0xff {
+		t.Errorf("mulaw(0) = %#x, want 0xff", mulaw(0))
+	}
+}
+
+// 16 bit PCM of a ramp, which is easy to check after a round trip
+func rampPCM(frames int, channels int) ([]byte, []int16) {
+	samples := make([]int16, frames*channels)
+	pcm := make([]byte, 0, len(samples)*2)
+	for i := range samples {
+		samples[i] = int16((i*97)%65536 - 32768)
+		pcm = binary.LittleEndian.AppendUint16(pcm, uint16(samples[i]))
+	}
+	return pcm, samples
+}
+
+func TestMulawEncoder(t *testing.T) {
+	pcm, samples := rampPCM(100, 2)
+	var out bytes.Buffer
+	w, err := newMulawEncoder(&out, AudioFormat{SampleRate: 44100, Channels: 2, BitsPerSample: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// written in two parts, like the mixer's writes
+	if _, err := w.Write(pcm[:100]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(pcm[100:]); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != len(samples) {
+		t.Fatalf("encoded %d bytes, want one per sample, %d", out.Len(), len(samples))
+	}
+	for i, sample := range samples {
+		if out.Bytes()[i] != mulaw(sample) {
+			t.Fatalf("byte %d = %#x, want %#x", i, out.Bytes()[i], mulaw(sample))
+		}
+	}
+}
+
+func TestWAVEncoderRoundTrip(t *testing.T) {
+	format := AudioFormat{SampleRate: 22050, Channels: 2, BitsPerSample: 16}
+	pcm, samples := rampPCM(100, 2)
+	var out bytes.Buffer
+	w, err := newWAVEncoder(&out, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(pcm); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "stream.wav")
+	if err := os.WriteFile(path, out.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	source, err := openSampleSource(f)
+	if err != nil {
+		t.Fatalf("openSampleSource() error = %v", err)
+	}
+	if source.SampleRate() != format.SampleRate || source.Channels() != format.Channels || source.Length() != -1 {
+		t.Errorf("got %d Hz, %d channels and length %d, want %d Hz, %d channels and an unknown length",
+			source.SampleRate(), source.Channels(), source.Length(), format.SampleRate, format.Channels)
+	}
+	decoded := make([]float32, len(samples)+2)
+	n, err := source.Read(decoded)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if n != len(samples) {
+		t.Fatalf("read %d samples, want %d", n, len(samples))
+	}
+	for i, sample := range samples {
+		if got := int16(decoded[i] * (1 << 15)); got != sample {
+			t.Fatalf("sample %d = %d, want %d", i, got, sample)
+		}
+	}
+}
+
+// decodes a whole FLAC stream into interleaved samples, checking it is in the format
+func decodeFLAC(t *testing.T, r io.Reader, format AudioFormat) []int16 {
+	t.Helper()
+	stream, err := flac.New(r)
+	if err != nil {
+		t.Fatalf("flac.New() error = %v", err)
+	}
+	if int(stream.Info.SampleRate) != format.SampleRate || int(stream.Info.NChannels) != format.Channels {
+		t.Errorf("stream is %d Hz with %d channels, want %d Hz with %d", stream.Info.SampleRate, stream.Info.NChannels, format.SampleRate, format.Channels)
+	}
+	decoded := []int16{}
+	for {
+		frame, err := stream.ParseNext()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ParseNext() error = %v", err)
+		}
+		for i := range frame.Subframes[0].NSamples {
+			for _, subframe := range frame.Subframes {
+				decoded = append(decoded, int16(subframe.Samples[i]))
+			}
+		}
+	}
+	return decoded
+}
+
+func TestFLACEncoderRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		channels int
+	}{
+		{"mono", 1},
+		{"stereo", 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			format := AudioFormat{SampleRate: 44100, Channels: test.channels, BitsPerSample: 16}
+			// whole blocks, since the encoder holds on to a partial one
+			pcm, samples := rampPCM(3*flacBlockSize, test.channels)
+			var out bytes.Buffer
+			w, err := newFLACEncoder(&out, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// written in uneven parts so blocks span writes
+			for start := 0; start < len(pcm); start += 1000 {
+				if _, err := w.Write(pcm[start:min(start+1000, len(pcm))]); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			decoded := decodeFLAC(t, &out, format)
+			if len(decoded) != len(samples) {
+				t.Fatalf("decoded %d samples, want %d", len(decoded), len(samples))
+			}
+			for i := range samples {
+				if decoded[i] != samples[i] {
+					t.Fatalf("sample %d = %d, want %d", i, decoded[i], samples[i])
+				}
+			}
+		})
+	}
+}
+
+func TestOggFLACEncoderRoundTrip(t *testing.T) {
+	// the check value of CRC-32/CKSUM, which is the same CRC with its result inverted
+	if crc := oggCRC([]byte("123456789")); crc != ^uint32(0x765e7680) {
+		t.Fatalf("oggCRC() = %#x, want %#x", crc, ^uint32(0x765e7680))
+	}
+
+	format := AudioFormat{SampleRate: 44100, Channels: 2, BitsPerSample: 16}
+	pcm, samples := rampPCM(3*flacBlockSize, format.Channels)
+	var out bytes.Buffer
+	w, err := newOggFLACEncoder(&out, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(pcm); err != nil {
+		t.Fatal(err)
+	}
+
+	// unwraps the pages, which each hold one whole packet
+	packets := [][]byte{}
+	granules := []uint64{}
+	page := out.Bytes()
+	for len(page) > 0 {
+		if len(page) < 27 || string(page[:4]) != "OggS" {
+			t.Fatalf("page %d has no Ogg capture pattern", len(packets))
+		}
+		if begin := page[5]&oggBeginStream != 0; begin != (len(packets) == 0) {
+			t.Errorf("page %d has the beginning of stream flag %v", len(packets), begin)
+		}
+		segments := int(page[26])
+		length := 0
+		for _, segment := range page[27 : 27+segments] {
+			length += int(segment)
+		}
+		end := 27 + segments + length
+		crc := binary.LittleEndian.Uint32(page[22:])
+		unchecked := bytes.Clone(page[:end])
+		binary.LittleEndian.PutUint32(unchecked[22:], 0)
+		if oggCRC(unchecked) != crc {
+			t.Errorf("page %d has CRC %#x, want %#x", len(packets), crc, oggCRC(unchecked))
+		}
+		if sequence := binary.LittleEndian.Uint32(page[18:]); int(sequence) != len(packets) {
+			t.Errorf("page %d has sequence number %d", len(packets), sequence)
+		}
+		granules = append(granules, binary.LittleEndian.Uint64(page[6:]))
+		packets = append(packets, page[27+segments:end])
+		page = page[end:]
+	}
+
+	if len(packets) != 5 {
+		t.Fatalf("got %d packets, want 2 header packets and 3 frames", len(packets))
+	}
+	if !bytes.HasPrefix(packets[0], []byte("\x7fFLAC\x01\x00\x00\x01fLaC")) {
+		t.Fatalf("first packet starts with %q, want the Ogg FLAC header", packets[0][:min(len(packets[0]), 13)])
+	}
+	for i, want := range []uint64{0, 0, flacBlockSize, 2 * flacBlockSize, 3 * flacBlockSize} {
+		if granules[i] != want {
+			t.Errorf("page %d has granule position %d, want %d", i, granules[i], want)
+		}
+	}
+
+	// without the mapping's header the packets make up a plain FLAC stream
+	native := bytes.Join(append([][]byte{packets[0][9:]}, packets[1:]...), nil)
+	decoded := decodeFLAC(t, bytes.NewReader(native), format)
+	if len(decoded) != len(samples) {
+		t.Fatalf("decoded %d samples, want %d", len(decoded), len(samples))
+	}
+	for i := range samples {
+		if decoded[i] != samples[i] {
+			t.Fatalf("sample %d = %d, want %d", i, decoded[i], samples[i])
+		}
+	}
+}
+
+func TestFindAudioEncoding(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		err  string
+	}{
+		{"flac", "flac", ""},
+		{"MULAW", "mulaw", ""},
+		{"ogg", "ogg", ""},
+		{"opus", "", "no Opus encoder"},
+		{"aac", "", "unknown format"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoding, err := FindAudioEncoding(test.name)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("FindAudioEncoding(%q) error = %v, want one containing %q", test.name, err, test.err)
+				}
+				return
+			}
+			if err != nil || encoding.Name != test.want {
+				t.Errorf("FindAudioEncoding(%q) = %q, %v, want %q", test.name, encoding.Name, err, test.want)
+			}
+		})
+	}
+}
@@ -5,8 +5,10 @@ import (
 	"image/color"
 	"math"
 	"math/rand/v2"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	stopwatch "github.com/charmbracelet/bubbles/v2/stopwatch"
 	tea "github.com/charmbracelet/bubbletea/v2"
@@ -18,51 +20,259 @@ import (
 type NotePos struct {
 	position float64
 	length   float64
+	chord    *chordState
+	// the fret was released before the end of the sustain
+	dropped bool
+}
+
+// the notes on a single tick, which are judged together
+type chordState struct {
+	frets [5]bool
+	// the star power phrase this chord is part of, if any
+	phrase *phraseState
+	hopo   bool
+	tap    bool
+	// open chords are played by strumming without any frets held, and are drawn across every lane
+	open   bool
+	judged bool
+	hit    bool
+	// chart time in seconds the chord should be hit at
+	time float64
+}
+
+func (c *chordState) size() int {
+	if c.open {
+		return 1
+	}
+	size := 0
+	for i := range c.frets {
+		if c.frets[i] {
+			size++
+		}
+	}
+	return size
+}
+
+// reports whether the held frets play this chord. Chords need exactly their frets held,
+// single notes can be anchored by also holding lower frets and open notes need no frets held
+func (c *chordState) matches(held []bool) bool {
+	if c.open {
+		return !slices.Contains(held, true)
+	}
+	if c.size() > 1 {
+		for i := range c.frets {
+			if held[i] != c.frets[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	highest := -1
+	for i := range held {
+		if held[i] {
+			highest = i
+		}
+	}
+	return highest >= 0 && c.frets[highest]
+}
+
+// formats frets like G+Y for logs
+func formatFrets(frets []bool) string {
+	names := []string{"G", "R", "Y", "B", "O"}
+	out := []string{}
+	for i := range frets {
+		if frets[i] {
+			out = append(out, names[i])
+		}
+	}
+	if len(out) == 0 {
+		return "-"
+	}
+	return strings.Join(out, "+")
+}
+
+// progress of a star power phrase which is on the highway
+type phraseState struct {
+	// chords of the phrase which have not been hit or missed yet
+	remaining int
+	// the cursor has passed the end of the phrase, so no more notes will be added
+	ended  bool
+	missed bool
+}
+
+// a global event waiting for the notes around it to reach the target
+type pendingEvent struct {
+	at    float64
+	event gotar_hero.GlobalEvent
 }
 
 type Game struct {
-	width        int
-	height       int
-	stopwatch    stopwatch.Model
-	mixer        *AudioMixer
-	held         []bool
-	cursor       gotar_hero.ChartCursor
-	prevTime     float64
-	notes        [][]NotePos
-	accTime      float64
-	startedAudio bool
-	strumming    bool
-	strumInfo    string
-	score        float64
+	width  int
+	height int
+	// the menu to go back to once the song is over
+	menu Menu
+	song Song
+	// half-characters per second the notes move at
+	noteSpeed int
+	stopwatch stopwatch.Model
+	mixer     *AudioMixer
+	held      []bool
+	cursor    gotar_hero.ChartCursor
+	prevTime  float64
+	notes     [][]NotePos
+	accTime   float64
+	strumming bool
+	score     float64
+	pending   []pendingEvent
+	section   string
+	phrase    []gotar_hero.GlobalEvent
+	sung      int
+	// the star power phrase the cursor is currently in
+	starPhrase *phraseState
+	// star power meter from 0 to 1
+	starPower       float64
+	starPowerActive bool
+	// chords hit in a row, while it is above zero HOPOs can be played without strumming
+	combo    int
+	maxCombo int
+	// notes hit and judged so far
+	notesHit    int
+	notesJudged int
+	laneHits    [5]int
+	laneMisses  [5]int
+	openHits    int
+	openMisses  int
+	// a fret was pressed or released, which plays HOPOs and taps
+	fretChanged bool
+	// the last chord which came into the scoring windows
+	entered *chordState
+	whammy  bool
+	// the last judgement and the clock time and offset it was given at
+	judgement       *Judgement
+	judgedAt        float64
+	judgementOffset float64
+	// the song's stems, whose position is the game clock
+	audio *PlaybackGroup
+	// stem of the instrument being played, ducked while the player is missing
+	stem string
+	// seconds the player's input lags behind what they hear and see, from their calibration
+	audioOffset float64
+	videoOffset float64
+	// the pause menu is open, which pauses the mixer and with it the clock
+	paused        bool
+	pauseSelected int
+	// the pause menu shows the settings instead of its buttons
+	pauseSettings bool
+	// when the countdown to carrying on started, zero unless it is counting down
+	resumeAt time.Time
+	// tells the ticks of an old pause apart from the current one
+	pauses int
 }
 
 var (
 	NoteSpawn = 450
 	NoteSpeed = 200
+	// the multiplier goes up by one every ComboStep chords hit in a row, up to MaxMultiplier
+	ComboStep     = 10
+	MaxMultiplier = 4
+	// sound played when a note is missed
+	MissSound = "strum2.raw"
+	// volume of the stem of the player's instrument after a miss until the next hit, 0 mutes it
+	MissVolume = 0.0
 )
 
+var starPowerColor = lipgloss.Color("#3ad6e8")
+
+// position notes have to be hit at, in half-character coordinates
+const targetPosition = 10.0
+
+// seconds it takes a note to get from where it spawns to the target
+func (m Game) travelTime() float64 {
+	return (float64(NoteSpawn) - targetPosition) / float64(m.noteSpeed)
+}
+
+func NewGame(menu Menu, song Song, track string) (Game, error) {
+	cursor, err := gotar_hero.NewChartCursor(*song.Chart, track)
+	if err != nil {
+		return Game{}, err
+	}
+	m := Game{
+		width:     menu.width,
+		height:    menu.height,
+		menu:      menu,
+		song:      song,
+		noteSpeed: menu.profile.noteSpeed(),
+		stopwatch: stopwatch.New(stopwatch.WithInterval(10 * time.Millisecond)),
+		mixer:     menu.mixer,
+		held:      make([]bool, 5),
+		notes:     make([][]NotePos, 5),
+		cursor:    *cursor,
+		stem:      instrumentStems[cursor.Track().Instrument],
+		// calibrated in milliseconds
+		audioOffset: float64(menu.profile.Settings.AudioOffset) / 1000,
+		videoOffset: float64(menu.profile.Settings.VideoOffset) / 1000,
+	}
+
+	// the song leads in with silence while the first notes travel to the target
+	lead := time.Duration((m.travelTime() + song.Chart.Offset) * float64(time.Second))
+	m.audio = m.mixer.PlaySong(song.Stems, 1.0, -lead)
+	return m, nil
+}
+
+// seconds since the game started, from the song's audio so the notes can't drift from it. The
+// notes at the target are at chart time clock - travelTime.
+func (m Game) clock() float64 {
+	// mixed audio is only heard once the lead the stream keeps over real time has played
+	heard := m.audio.Position() - AudioLatency
+	return max(0, heard.Seconds()+m.song.Chart.Offset+m.travelTime())
+}
+
 func (m Game) Init() tea.Cmd {
 	return m.stopwatch.Init()
 }
 
 func (m Game) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	// keep the menu up to date for when we go back to it
+	if cmd, ok := m.menu.updateShared(msg, &m.width, &m.height); ok {
+		return m, cmd
+	}
 	m.strumming = false
+	m.fretChanged = false
 	switch msg := msg.(type) {
 	case tea.KeyPressMsg:
 		log.Info("pressed", "key", msg.Key().Text)
+		if m.paused {
+			return m.updatePause(msg)
+		}
 		switch msg.Key().String() {
 		case "1":
 			m.held[0] = true
+			m.fretChanged = true
 		case "2":
 			m.held[1] = true
+			m.fretChanged = true
 		case "3":
 			m.held[2] = true
+			m.fretChanged = true
 		case "4":
 			m.held[3] = true
+			m.fretChanged = true
 		case "5":
 			m.held[4] = true
+			m.fretChanged = true
 		case "j", "k", "space":
 			m.strumming = true
+		case "w":
+			m.whammy = true
+		case "e":
+			// star power can only be activated with at least half a meter
+			if !m.starPowerActive && m.starPower >= 0.5 {
+				log.Info("activated star power", "meter", m.starPower)
+				m.starPowerActive = true
+			}
+		case "esc", "p":
+			return m, m.pause()
 		case "q", "ctrl+c":
 			return m, tea.Quit
 		}
@@ -71,30 +281,108 @@ func (m Game) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		switch msg.Key().Text {
 		case "1":
 			m.held[0] = false
+			m.fretChanged = true
 		case "2":
 			m.held[1] = false
+			m.fretChanged = true
 		case "3":
 			m.held[2] = false
+			m.fretChanged = true
 		case "4":
 			m.held[3] = false
+			m.fretChanged = true
 		case "5":
 			m.held[4] = false
+			m.fretChanged = true
+		case "w":
+			m.whammy = false
 		}
-	case tea.WindowSizeMsg:
-		m.width = msg.Width
-		m.height = msg.Height
+	case pauseTickMsg:
+		if !m.paused || msg.pause != m.pauses {
+			return m, nil
+		}
+		if !m.resumeAt.IsZero() && time.Since(m.resumeAt) >= resumeCountdown {
+			return m, m.resume()
+		}
+		return m, m.pauseTick()
 	}
 	var cmd tea.Cmd
 	m.stopwatch, cmd = m.stopwatch.Update(msg)
 
+	if m.paused {
+		return m, cmd
+	}
+
 	done := m.update()
 	if done {
-		return m, tea.Quit
+		result := m.result()
+		log.Info("song finished", "score", result.Score, "maxCombo", result.MaxCombo, "hit", fmt.Sprintf("%.1f%%", result.HitPercentage()))
+		m.menu.profile.PlayCount++
+		m.menu.profile.TotalScore += result.Score
+		m.menu.saveProfile()
+		err := m.menu.leaderboard.Record(Run{
+			Fingerprint: m.menu.profile.Fingerprint,
+			User:        m.menu.profile.Name,
+			Song:        m.song.ID,
+			Difficulty:  m.cursor.Track().Name,
+			Score:       result.Score,
+			Accuracy:    result.HitPercentage(),
+			MaxCombo:    result.MaxCombo,
+			Time:        time.Now(),
+		})
+		if err != nil {
+			log.Error("failed to record run", "error", err)
+		}
+		results := Results{
+			width:  m.width,
+			height: m.height,
+			menu:   m.menu,
+			song:   m.song,
+			track:  m.cursor.Track().Name,
+			result: result,
+			audio:  m.audio,
+		}
+		return results, results.Init()
 	}
 
 	return m, cmd
 }
 
+// the outcome of a played song
+type Result struct {
+	Score    int
+	MaxCombo int
+	NotesHit int
+	Notes    int
+	// notes hit and missed in each lane
+	LaneHits   [5]int
+	LaneMisses [5]int
+	// open notes hit and missed, which have no lane
+	OpenHits   int
+	OpenMisses int
+}
+
+// percentage of the notes in the song which were hit
+func (r Result) HitPercentage() float64 {
+	if r.Notes == 0 {
+		return 0
+	}
+	return 100 * float64(r.NotesHit) / float64(r.Notes)
+}
+
+func (m Game) result() Result {
+	return Result{
+		Score:      int(m.score),
+		MaxCombo:   m.maxCombo,
+		NotesHit:   m.notesHit,
+		Notes:      m.notesJudged,
+		LaneHits:   m.laneHits,
+		LaneMisses: m.laneMisses,
+		OpenHits:   m.openHits,
+		OpenMisses: m.openMisses,
+	}
+}
+
 func floordiv(a, b int) int {
 	return (a - mod(a, b)) / b
 }
@@ -110,53 +398,124 @@ type rowColors struct {
 	overlap   color.Color
 }
 
-// postitions is an array of half-character coordinates
-func renderRow(charWidth int, positions []NotePos, held bool, colors rowColors, ticks_per_char float64) string {
-	// a := []rune("\u2588\u2588\u2588\u2588 ")
-	// b := []rune("\u2590\u2588\u2588\u2588\u258c")
+const (
+	cellEmpty = iota
+	cellNote
+	// part of a sustain which has already been played
+	cellPlayed
+	// rest of a sustain which was released early or missed
+	cellDropped
+)
+
+// postitions is an array of half-character coordinates, which are drawn shift half-characters further along
+func renderRow(charWidth int, positions []NotePos, held bool, colors rowColors, ticks_per_char float64, shift float64) string {
 	result := ""
 	result += lipgloss.NewStyle().Foreground(colors.boxBorder).Render("   ┌──────┐") + "\n"
 	line := make([]rune, charWidth)
+	kinds := make([]int, charWidth)
 	for i := range charWidth {
 		line[i] = ' '
 	}
+	set := func(i int, r rune, kind int) {
+		if i >= 0 && i < charWidth {
+			line[i] = r
+			kinds[i] = kind
+		}
+	}
+	targetChar := floordiv(int(targetPosition), 2)
 	for _, pos := range positions {
-		posChar := floordiv(int(pos.position), 2)
-		posMod := mod(int(pos.position), 2)
-		char_len := max(5, int(pos.length/ticks_per_char))
-		if posMod == 0 {
-			for i := range char_len {
-				if posChar+i >= 0 && posChar+i < charWidth {
-					line[posChar+i] = '\u2588'
-				}
+		posChar := floordiv(int(pos.position+shift), 2)
+
+		// the tail is drawn first so the head of the note covers it
+		tail_len := int(pos.length / ticks_per_char)
+		dropped := pos.dropped || (pos.chord.judged && !pos.chord.hit)
+		for i := range tail_len {
+			switch {
+			case dropped:
+				set(posChar+i, '\u254d', cellDropped)
+			case pos.chord.hit && posChar+i < targetChar:
+				set(posChar+i, '\u2501', cellPlayed)
+			default:
+				set(posChar+i, '\u2501', cellNote)
 			}
-		} else {
-			for i := range char_len {
-				if posChar+i >= 0 && posChar+i < charWidth {
-					if i == 0 {
-						line[posChar+i] = '\u2590'
-					}
-					if i == charWidth {
-						line[posChar+i] = '\u258c'
-					}
-					line[posChar+i] = '\u2588'
-				}
+		}
+
+		// the head of a hit sustain is gone, only the tail is left
+		if pos.chord.hit {
+			continue
+		}
+		// HOPOs and taps are drawn with a lighter shade so they stand out from strummed notes
+		glyph := '\u2588'
+		if pos.chord.tap {
+			glyph = '\u2592'
+		} else if pos.chord.hopo {
+			glyph = '\u2593'
+		}
+		// open notes are a bar across the lanes, so they are drawn thinner than fretted notes
+		if pos.chord.open {
+			glyph = '\u2550'
+		}
+		for i := range 5 {
+			set(posChar+i, glyph, cellNote)
+		}
+	}
+
+	style := func(i int) lipgloss.Style {
+		style := lipgloss.NewStyle().Foreground(colors.note)
+		switch kinds[i] {
+		case cellPlayed:
+			style = style.Foreground(lighten(colors.note, 50))
+		case cellDropped:
+			style = style.Foreground(subtle)
+		}
+		if held && i >= 5 && i < 9 {
+			style = style.Background(colors.boxFill)
+			if kinds[i] == cellNote || kinds[i] == cellEmpty {
+				style = style.Foreground(colors.overlap)
 			}
 		}
+		return style
 	}
-	for range 2 {
-		if held {
-			result += lipgloss.NewStyle().Foreground(colors.note).Render(string(line[0:5]))
-			result += lipgloss.NewStyle().Foreground(colors.overlap).Background(colors.boxFill).Render(string(line[5:9]))
-			result += lipgloss.NewStyle().Foreground(colors.note).Render(string(line[9:])) + "\n"
-		} else {
-			result += lipgloss.NewStyle().Foreground(colors.note).Render(string(line)) + "\n"
+
+	// render runs of cells which share a style together
+	rendered := ""
+	for start := 0; start < charWidth; {
+		end := start + 1
+		for end < charWidth && kinds[end] == kinds[start] && end != 5 && end != 9 {
+			end++
 		}
+		rendered += style(start).Render(string(line[start:end]))
+		start = end
+	}
+
+	for range 2 {
+		result += rendered + "\n"
 	}
 	result += lipgloss.NewStyle().Foreground(colors.boxBorder).Render("   └──────┘") + "\n"
 	return result
 }
 
+func renderCombo(combo int, multiplier int, maxCombo int) string {
+	multiplierStyle := lipgloss.NewStyle().Foreground(highlight).Bold(true)
+	if multiplier > MaxMultiplier {
+		multiplierStyle = multiplierStyle.Foreground(starPowerColor)
+	}
+	return fmt.Sprintf("Combo: %d %s  Best: %d", combo, multiplierStyle.Render(fmt.Sprintf("%dx", multiplier)), maxCombo)
+}
+
+func renderStarPower(meter float64, active bool) string {
+	const width = 20
+	filled := int(meter * width)
+	bar := strings.Repeat("█", filled) + strings.Repeat("░", width-filled)
+	label := "Star Power "
+	if active {
+		label = "STAR POWER "
+	} else if meter >= 0.5 {
+		label = "Star Power (e) "
+	}
+	return label + lipgloss.NewStyle().Foreground(starPowerColor).Render(bar)
+}
+
 func lighten(c color.Color, percent float64) color.Color {
 	r16, g16, b16, _ := c.RGBA()
 
@@ -180,32 +539,284 @@ func lighten(c color.Color, percent float64) color.Color {
 	return lipgloss.Color(fmt.Sprintf("#%02x%02x%02x", r2, g2, b2))
 }
 
-func (m *Game) handleEvents(events []any) {
+func (m *Game) handleEvents(events []any, now float64) {
 	for i := range events {
 		switch u := events[i].(type) {
 		case []gotar_hero.Note:
+			chord := &chordState{phrase: m.starPhrase, time: m.cursor.Chart.TickTime(u[0].Tick)}
+			notes := []gotar_hero.Note{}
+			lanes := []int{}
+			open := []gotar_hero.Note{}
 			for j := range u {
 				note := u[j]
-				if note.Typ > 4 || note.Typ < 0 {
+				if note.Open {
+					open = append(open, note)
+					continue
+				}
+				lane := m.cursor.Track().Instrument.Lane(note.Typ)
+				if lane < 0 {
 					// silently discared bad notes
 					continue
 				}
-				m.notes[note.Typ] = append(m.notes[note.Typ], NotePos{float64(NoteSpawn), float64(note.Len)})
+				chord.frets[lane] = true
+				chord.hopo = note.HOPO
+				chord.tap = note.Tap
+				notes = append(notes, note)
+				lanes = append(lanes, lane)
+			}
+			// an open note on its own is put in every lane, it is dropped from a chord with frets
+			if len(lanes) == 0 && len(open) > 0 {
+				chord.open = true
+				chord.hopo = open[0].HOPO
+				chord.tap = open[0].Tap
+				for lane := range 5 {
+					notes = append(notes, open[0])
+					lanes = append(lanes, lane)
+				}
+			}
+			if len(lanes) == 0 {
+				continue
+			}
+			if m.starPhrase != nil {
+				m.starPhrase.remaining++
+			}
+			for j, note := range notes {
+				m.notes[lanes[j]] = append(m.notes[lanes[j]], NotePos{float64(NoteSpawn), float64(note.Len), chord, false})
 			}
 			// notes
+		case gotar_hero.PhraseStart:
+			for j := range u {
+				if u[j].Kind == gotar_hero.PhraseStarPower {
+					m.starPhrase = &phraseState{}
+				}
+			}
+		case gotar_hero.PhraseEnd:
+			for j := range u {
+				if u[j].Kind == gotar_hero.PhraseStarPower && m.starPhrase != nil {
+					m.starPhrase.ended = true
+					m.judgePhrase(m.starPhrase)
+					m.starPhrase = nil
+				}
+			}
 		case *gotar_hero.TempoChange:
 			// tempo changes are automatically handled by the cursor
 		case *gotar_hero.TSChange:
 			// time signature change are automatically handled by the cursor
+		case []gotar_hero.GlobalEvent:
+			// the cursor runs ahead of the target by the time it takes a note to get there,
+			// so hold these back until then
+			for j := range u {
+				m.pending = append(m.pending, pendingEvent{now + m.travelTime(), u[j]})
+			}
 		}
 	}
 }
 
+func (m *Game) handleGlobalEvent(event gotar_hero.GlobalEvent) {
+	switch event.Kind {
+	case gotar_hero.EventSection:
+		m.section = event.Text
+	case gotar_hero.EventPhraseStart:
+		m.phrase = m.cursor.Chart.PhraseLyrics(event.Tick)
+		m.sung = 0
+	case gotar_hero.EventLyric:
+		m.sung = min(m.sung+1, len(m.phrase))
+	case gotar_hero.EventPhraseEnd:
+		m.phrase = nil
+		m.sung = 0
+	}
+}
+
+// shows a judgement in the view for a moment
+func (m *Game) flash(judgement Judgement, offset float64) {
+	m.judgement = &judgement
+	m.judgedAt = m.prevTime
+	m.judgementOffset = offset
+}
+
+// judges a chord played with the given timing error in seconds, strums too late to score miss it
+func (m *Game) hitChord(chord *chordState, offset float64) {
+	judgement := judge(offset)
+	if judgement.Name == missJudgement().Name {
+		m.missChord(chord, offset)
+		return
+	}
+	log.Info("hit chord", "frets", formatFrets(chord.frets[:]), "judgement", judgement.Name, "offset", offset, "hopo", chord.hopo, "tap", chord.tap)
+	chord.judged = true
+	chord.hit = true
+	m.combo++
+	m.maxCombo = max(m.maxCombo, m.combo)
+	m.notesHit += chord.size()
+	m.notesJudged += chord.size()
+	for i := range chord.frets {
+		if chord.frets[i] {
+			m.laneHits[i]++
+		}
+	}
+	if chord.open {
+		m.openHits++
+	}
+	m.flash(judgement, offset)
+	m.score += judgement.Score * float64(chord.size()) * m.multiplier()
+	m.judgePhraseChord(chord.phrase, true)
+	m.setStemVolume(1.0)
+}
+
+// sets the volume of the stem of the instrument being played, if the song has it
+func (m *Game) setStemVolume(volume float64) {
+	if m.audio == nil {
+		return
+	}
+	if handle := m.audio.Get(m.stem); handle != nil {
+		handle.SetVolume(volume)
+	}
+}
+
+// scores a held sustain, whammying a sustain in a star power phrase also fills the meter. It is
+// called for every held lane of a chord, so each lane gets its share of the chord.
+func (m *Game) sustain(pos NotePos, deltaTime float64) {
+	lanes := pos.chord.size()
+	if pos.chord.open {
+		lanes = len(m.held)
+	}
+	deltaTime /= float64(lanes)
+	beatsPerSecond := m.cursor.CurrentTicksPerSecond() / float64(m.cursor.Chart.Resolution)
+	m.score += deltaTime * beatsPerSecond * 25.0 * m.multiplier()
+	if m.whammy && pos.chord.phrase != nil {
+		m.starPower = min(1.0, m.starPower+deltaTime*beatsPerSecond/32.0)
+	}
+}
+
+func (m *Game) missChord(chord *chordState, offset float64) {
+	log.Info("missed", "frets", formatFrets(chord.frets[:]), "offset", offset)
+	chord.judged = true
+	m.breakCombo()
+	m.notesJudged += chord.size()
+	for i := range chord.frets {
+		if chord.frets[i] {
+			m.laneMisses[i]++
+		}
+	}
+	if chord.open {
+		m.openMisses++
+	}
+	m.flash(missJudgement(), offset)
+	m.score += missJudgement().Score
+	m.judgePhraseChord(chord.phrase, false)
+	volume := rand.Float64() / 2.0
+	m.mixer.Play(MissSound, 0.5+volume)
+}
+
+// records a judged chord of a star power phrase
+func (m *Game) judgePhraseChord(phrase *phraseState, hit bool) {
+	if phrase == nil {
+		return
+	}
+	phrase.remaining--
+	if !hit {
+		phrase.missed = true
+	}
+	m.judgePhrase(phrase)
+}
+
+// fills the star power meter once every chord of a phrase has been hit
+func (m *Game) judgePhrase(phrase *phraseState) {
+	if !phrase.ended || phrase.remaining > 0 || phrase.missed {
+		return
+	}
+	// only award a phrase once
+	phrase.missed = true
+	m.starPower = min(1.0, m.starPower+0.25)
+	log.Info("completed star power phrase", "meter", m.starPower)
+}
+
+// missed and wrongly strummed notes break the combo and duck the player's instrument
+func (m *Game) breakCombo() {
+	if m.combo > 0 {
+		log.Info("combo broken", "combo", m.combo)
+	}
+	m.combo = 0
+	m.setStemVolume(MissVolume)
+}
+
+// the multiplier earned from the combo, star power doubles it on top
+func (m Game) comboMultiplier() int {
+	return min(1+m.combo/ComboStep, MaxMultiplier)
+}
+
+// the factor every score gain is multiplied by
+func (m Game) multiplier() float64 {
+	if m.starPowerActive {
+		return float64(m.comboMultiplier()) * 2
+	}
+	return float64(m.comboMultiplier())
+}
+
+// renders the current phrase with the already sung syllables highlighted
+func renderLyrics(phrase []gotar_hero.GlobalEvent, sung int) string {
+	sungText := ""
+	restText := ""
+	for i := range phrase {
+		syllable := phrase[i].Text
+		// a trailing dash joins a syllable to the next one
+		joined := strings.HasSuffix(syllable, "-")
+		syllable = strings.TrimSuffix(syllable, "-")
+		if !joined && i != len(phrase)-1 {
+			syllable += " "
+		}
+		if i < sung {
+			sungText += syllable
+		} else {
+			restText += syllable
+		}
+	}
+	return lipgloss.NewStyle().Foreground(highlight).Bold(true).Render(sungText) + lipgloss.NewStyle().Foreground(normal).Render(restText)
+}
+
+// judges the strum and frets of this update against the earliest chord which can still be hit,
+// at the chart time songTime
+func (m *Game) judgeInput(songTime float64) {
+	// the earliest chord which can still be hit
+	var candidate *chordState
+	for i := range 5 {
+		for _, pos := range m.notes[i] {
+			if pos.chord.judged || math.Abs(songTime-pos.chord.time) > missWindow() {
+				continue
+			}
+			if candidate == nil || pos.chord.time < candidate.time {
+				candidate = pos.chord
+			}
+		}
+	}
+
+	if candidate != nil {
+		offset := songTime - candidate.time
+		// the frets are checked when they change and when the chord comes into the windows, so
+		// a HOPO or tap whose frets are already held is played as it arrives
+		entering := candidate != m.entered && !tooEarly(offset)
+		if entering {
+			m.entered = candidate
+		}
+		// HOPOs only need the frets if the note before was hit, taps never need a strum
+		strummed := m.strumming || ((m.fretChanged || entering) && (candidate.tap || (candidate.hopo && m.combo > 0)))
+		if strummed && candidate.matches(m.held) && !tooEarly(offset) {
+			m.hitChord(candidate, offset)
+		} else if m.strumming {
+			// the chord is left to be hit on time
+			log.Info("wrong frets or too early", "held", formatFrets(m.held), "chord", formatFrets(candidate.frets[:]), "offset", offset)
+			m.breakCombo()
+			m.flash(overstrum, 0)
+		}
+	} else if m.strumming {
+		log.Info("overstrum", "held", formatFrets(m.held))
+		m.breakCombo()
+		m.flash(overstrum, 0)
+	}
+}
+
 func (m *Game) update() bool {
-	m.mixer.mu.Lock()
-	newTime := m.mixer.elapsedTime
+	newTime := max(m.prevTime, m.clock())
 	deltaTime := newTime - m.prevTime
-	m.mixer.mu.Unlock()
 
 	events, adv := m.cursor.NextEvent()
 	advTime := float64(adv) / m.cursor.CurrentTicksPerSecond()
@@ -216,9 +827,10 @@ func (m *Game) update() bool {
 	// if we have accumalated more time than needs to be advanced
 	// we need to consume these events
 	// log.Info("tick", "adv", adv)
-	for m.accTime >= advTime && adv > 0 {
+	// the events on tick 0 come with an advance of zero, the chart is over once there are none
+	for m.accTime >= advTime && len(events) > 0 {
 		// consume the events
-		m.handleEvents(events)
+		m.handleEvents(events, newTime)
 		m.accTime -= advTime
 		m.cursor.AdvanceTick(adv)
 		// setup next events
@@ -226,92 +838,89 @@ func (m *Game) update() bool {
 		advTime = float64(adv) / m.cursor.CurrentTicksPerSecond()
 	}
 
-	noteDist := make([]float64, 5)
+	remaining := m.pending[:0]
+	for _, pending := range m.pending {
+		if pending.at <= newTime {
+			m.handleGlobalEvent(pending.event)
+		} else {
+			remaining = append(remaining, pending)
+		}
+	}
+	m.pending = remaining
+
+	// chart time of the notes the player hears now. Input is handled in the same update it
+	// arrives in, so this is also the timestamp of any strum or fret change, which is late by the
+	// player's audio offset
+	songTime := newTime - m.travelTime() - m.audioOffset
+
+	m.judgeInput(songTime)
+
+	secondsPerChar := 2.0 / float64(m.noteSpeed)
+	ticksPerChar := m.cursor.CurrentTicksPerSecond() * secondsPerChar
+
 	for i := range 5 {
 		oldPositions := m.notes[i]
-		noteDist[i] = math.NaN()
 		m.notes[i] = make([]NotePos, 0.0)
-		if oldPositions == nil {
-			continue
-		}
 
-		for j := range oldPositions {
-			targetPosition := 10.0
-			dist := math.Abs(oldPositions[j].position - targetPosition)
-			if dist <= 32.0 && oldPositions[j].length == 0 {
-				noteDist[i] = dist
-				// delete hit notes that are 0 length
-				if m.strumming && m.held[i] {
-					log.Info("hit note", "note", i, "dist", dist)
-					m.score += 40 * (32 - dist)
-					continue
-				}
+		for _, pos := range oldPositions {
+			// delete hit notes that are 0 length
+			if pos.chord.hit && pos.length == 0 {
+				continue
 			}
 
-			secondsPerChar := 2.0 / float64(NoteSpeed)
-
-			ticksPerChar := m.cursor.CurrentTicksPerSecond() * secondsPerChar
+			if !pos.chord.judged && songTime-pos.chord.time > missWindow() {
+				m.missChord(pos.chord, songTime-pos.chord.time)
+			}
 
-			after := targetPosition - oldPositions[j].position
-			if after < oldPositions[j].length/ticksPerChar && after > 0 {
-				// we are in the note
-				if m.strumming && m.held[i] {
-					m.score += deltaTime * 100.0
-					log.Info("strumming in held note", "dt", deltaTime, "score", m.score)
-					// make this not NaN so this is not considered a false positive
-					noteDist[i] = 0
+			// tail length in half-character coordinates
+			tail := 2 * pos.length / ticksPerChar
+			after := targetPosition - pos.position
+			if pos.chord.hit && !pos.dropped && after < tail && after > 0 {
+				// we are in the note, open sustains are held by keeping every fret up
+				held := m.held[i]
+				if pos.chord.open {
+					held = !slices.Contains(m.held, true)
+				}
+				if held {
+					m.sustain(pos, deltaTime)
+				} else {
+					log.Info("released sustain early", "note", i, "left", (tail-after)/tail)
+					pos.dropped = true
 				}
 			}
 
-			if oldPositions[j].position+oldPositions[j].length/ticksPerChar >= -32.0 {
-				m.notes[i] = append(m.notes[i], NotePos{oldPositions[j].position - deltaTime*float64(NoteSpeed), oldPositions[j].length})
-			} else if oldPositions[j].length == 0 {
-				// for now just ignore missed long notes
-				log.Info("missed", "note", i)
-				m.strumInfo = fmt.Sprintf("miss %d", i)
-				m.score -= 50
-				volume := rand.Float64() / 2.0
-				m.mixer.Play("strum2.raw", 0.5+volume)
+			if pos.position+tail >= -32.0 {
+				pos.position -= deltaTime * float64(m.noteSpeed)
+				m.notes[i] = append(m.notes[i], pos)
 			}
 		}
 	}
 
-	total_positions := len(m.notes[0]) + len(m.notes[2]) + len(m.notes[3]) + len(m.notes[4])
-
-	if adv == 0 {
-		log.Info("no more events", "positions left", total_positions)
+	if m.starPowerActive {
+		// a full meter lasts for 32 beats
+		beatsPerSecond := m.cursor.CurrentTicksPerSecond() / float64(m.cursor.Chart.Resolution)
+		m.starPower -= deltaTime * beatsPerSecond / 32.0
+		if m.starPower <= 0 {
+			log.Info("star power ran out")
+			m.starPower = 0
+			m.starPowerActive = false
+		}
 	}
 
-	if adv == 0 && total_positions == 0 {
-		// all the notes have passed and there are no more events coming so we are done
-		return true
+	total_positions := 0
+	for i := range 5 {
+		total_positions += len(m.notes[i])
 	}
 
-	if m.strumming {
-		m.strumInfo = ""
-		for i := range 5 {
-			if m.held[i] {
-				if math.IsNaN(noteDist[i]) {
-					m.strumInfo += fmt.Sprintf("false positive %d; ", i)
-					// volume := rand.Float64() / 2.0
-					// m.mixer.Play("strum.raw", 0.5+volume)
-				} else {
-					m.strumInfo += fmt.Sprintf("distance %d %f; ", i, noteDist[i])
-				}
-			} else {
-				if math.IsNaN(noteDist[i]) {
-					// true negative
-				} else {
-					m.strumInfo += fmt.Sprintf("false negative %d; ", i)
-				}
-			}
-
-		}
+	if len(events) == 0 {
+		log.Info("no more events", "positions left", total_positions)
 	}
 
-	if newTime > (float64(NoteSpawn)-20.0)/float64(NoteSpeed) && !m.startedAudio {
-		_, _ = m.mixer.Play("audio.raw", 1.0)
-		m.startedAudio = true
+	if len(events) == 0 && total_positions == 0 {
+		// all the notes have passed and there are no more events coming so we are done. The
+		// rest of the song plays out over the results.
+		m.audio.StopClock()
+		return true
 	}
 
 	m.prevTime = newTime
@@ -360,24 +969,50 @@ func (m Game) View() tea.View {
 		overlap:   orange,
 	}
 
-	// result := m.strumInfo + " score: " + strconv.Itoa(int(m.score)) + "\n"
+	if m.starPowerActive {
+		// the whole highway lights up while star power is active
+		for _, colors := range []*rowColors{&greens, &reds, &yellows, &blues, &oranges} {
+			colors.note = starPowerColor
+			colors.overlap = starPowerColor
+			colors.boxFill = lighten(starPowerColor, 30)
+		}
+	}
+
+	judgement := ""
+	if m.judgement != nil && m.prevTime-m.judgedAt < judgementFlash {
+		judgement = renderJudgement(*m.judgement, m.judgementOffset)
+	}
 
-	secondsPerChar := 2.0 / float64(NoteSpeed)
+	secondsPerChar := 2.0 / float64(m.noteSpeed)
 	ticksPerChar := m.cursor.CurrentTicksPerSecond() * secondsPerChar
 
-	rows := renderRow(200, m.notes[0], m.held[0], greens, ticksPerChar)
-	rows += renderRow(200, m.notes[1], m.held[1], reds, ticksPerChar)
-	rows += renderRow(200, m.notes[2], m.held[2], yellows, ticksPerChar)
-	rows += renderRow(200, m.notes[3], m.held[3], blues, ticksPerChar)
-	rows += renderRow(200, m.notes[4], m.held[4], oranges, ticksPerChar)
+	// notes reach the target when a player who strums on sight is judged on time
+	shift := (m.audioOffset - m.videoOffset) * float64(m.noteSpeed)
+
+	rows := renderRow(200, m.notes[0], m.held[0], greens, ticksPerChar, shift)
+	rows += renderRow(200, m.notes[1], m.held[1], reds, ticksPerChar, shift)
+	rows += renderRow(200, m.notes[2], m.held[2], yellows, ticksPerChar, shift)
+	rows += renderRow(200, m.notes[3], m.held[3], blues, ticksPerChar, shift)
+	rows += renderRow(200, m.notes[4], m.held[4], oranges, ticksPerChar, shift)
 	rows = strings.TrimRight(rows, "\n")
+	if m.paused && m.resumeAt.IsZero() {
+		rows = m.renderPause(lipgloss.Width(rows), lipgloss.Height(rows))
+	}
 	rows = lipgloss.NewStyle().Border(lipgloss.NormalBorder()).Render(rows)
+	section := m.section
+	if countdown := m.countdown(); countdown > 0 {
+		section = fmt.Sprintf("Resuming in %d", countdown)
+	}
 	result := lipgloss.JoinVertical(0,
+		lipgloss.NewStyle().Foreground(highlight).Bold(true).Padding(0, 0, 0, 2).Render(section),
 		rows,
+		lipgloss.NewStyle().Padding(0, 0, 0, 2).Render(renderLyrics(m.phrase, m.sung)),
 		lipgloss.NewStyle().Foreground(subtle).Padding(0, 0, 0, 2).Render(lipgloss.JoinVertical(0,
 			m.stopwatch.View(),
 			"Score: "+strconv.Itoa(int(m.score)),
-			m.strumInfo,
+			renderCombo(m.combo, int(m.multiplier()), m.maxCombo),
+			renderStarPower(m.starPower, m.starPowerActive),
+			judgement,
 		)),
 	)
 
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	stopwatch "github.com/charmbracelet/bubbles/v2/stopwatch"
+	tea "github.com/charmbracelet/bubbletea/v2"
+	"github.com/charmbracelet/lipgloss/v2"
+	gotar_hero "github.com/mbund/terminal-hero/pkg/gotar-hero"
+)
+
+func TestChordMatches(t *testing.T) {
+	frets := func(lanes ...int) [5]bool {
+		out := [5]bool{}
+		for _, lane := range lanes {
+			out[lane] = true
+		}
+		return out
+	}
+	held := func(lanes ...int) []bool {
+		out := frets(lanes...)
+		return out[:]
+	}
+
+	tests := []struct {
+		name  string
+		chord chordState
+		held  []bool
+		want  bool
+	}{
+		{"single note", chordState{frets: frets(2)}, held(2), true},
+		{"single note anchored on lower frets", chordState{frets: frets(2)}, held(0, 1, 2), true},
+		{"single note with a higher fret held", chordState{frets: frets(2)}, held(2, 3), false},
+		{"single note with the wrong fret", chordState{frets: frets(2)}, held(1), false},
+		{"single note with nothing held", chordState{frets: frets(0)}, held(), false},
+		{"chord", chordState{frets: frets(0, 2)}, held(0, 2), true},
+		{"chord can't be anchored", chordState{frets: frets(1, 2)}, held(0, 1, 2), false},
+		{"chord missing a fret", chordState{frets: frets(1, 2)}, held(2), false},
+		{"open note with nothing held", chordState{open: true}, held(), true},
+		{"open note with a fret held", chordState{open: true}, held(0), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.chord.matches(test.held); got != test.want {
+				t.Errorf("matches(%s) = %v, want %v", formatFrets(test.held), got, test.want)
+			}
+		})
+	}
+}
+
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+func TestRenderRowSustains(t *testing.T) {
+	colors := rowColors{lipgloss.Color("#111111"), lipgloss.Color("#222222"), lipgloss.Color("#333333"), lipgloss.Color("#444444")}
+	tests := []struct {
+		name string
+		pos  NotePos
+		// the note's line without styles
+		want string
+	}{
+		{"note", NotePos{20, 0, &chordState{}, false}, "          █████               "},
+		{"sustain coming up", NotePos{20, 40, &chordState{}, false}, "          █████━━━━━          "},
+		{"sustain being held", NotePos{-10, 80, &chordState{judged: true, hit: true}, false}, "━━━━━━━━━━━━━━━               "},
+		{"sustain released early", NotePos{-10, 80, &chordState{judged: true, hit: true}, true}, "╍╍╍╍╍╍╍╍╍╍╍╍╍╍╍               "},
+		{"missed sustain", NotePos{20, 40, &chordState{judged: true}, false}, "          █████╍╍╍╍╍          "},
+		{"open note", NotePos{20, 0, &chordState{open: true}, false}, "          ═════               "},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rendered := renderRow(30, []NotePos{test.pos}, false, colors, 4, 0)
+			lines := strings.Split(ansiEscape.ReplaceAllString(rendered, ""), "\n")
+			if lines[1] != test.want {
+				t.Errorf("renderRow() line = %q, want %q", lines[1], test.want)
+			}
+		})
+	}
+
+	// the part of a held sustain before the target is drawn lighter than the rest
+	rendered := renderRow(30, []NotePos{{-10, 80, &chordState{judged: true, hit: true}, false}}, false, colors, 4, 0)
+	played := lipgloss.NewStyle().Foreground(lighten(colors.note, 50)).Render("━━━━━")
+	if !strings.Contains(rendered, played) {
+		t.Errorf("renderRow() = %q, want the played part of the sustain drawn as %q", rendered, played)
+	}
+}
+
+// a game with nothing on the highway, which judges input without a song playing
+func newTestGame() Game {
+	return Game{
+		mixer: NewAudioMixer(2, 1.0, 128, 44100, 2),
+		held:  make([]bool, 5),
+		notes: make([][]NotePos, 5),
+	}
+}
+
+func TestJudgeInputHeldFrets(t *testing.T) {
+	tests := []struct {
+		name  string
+		chord chordState
+		combo int
+		held  int
+		want  bool
+	}{
+		{"HOPO after a hit", chordState{hopo: true}, 1, 2, true},
+		{"HOPO without a combo needs a strum", chordState{hopo: true}, 0, 2, false},
+		{"tap without a combo", chordState{tap: true}, 0, 2, true},
+		{"strummed note needs a strum", chordState{}, 1, 2, false},
+		{"HOPO with the wrong fret", chordState{hopo: true}, 1, 1, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newTestGame()
+			chord := test.chord
+			chord.frets[2] = true
+			chord.time = 1
+			m.notes[2] = []NotePos{{position: float64(NoteSpawn), chord: &chord}}
+			m.combo = test.combo
+
+			// the fret is pressed before the chord comes into the windows
+			m.held[test.held] = true
+			m.fretChanged = true
+			m.judgeInput(chord.time - missWindow() + 0.01)
+			if chord.judged {
+				t.Fatal("chord was judged before it came into the windows")
+			}
+
+			// and is still held as it comes in
+			m.fretChanged = false
+			m.judgeInput(chord.time - 0.05)
+			if chord.hit != test.want {
+				t.Errorf("hit = %v, want %v", chord.hit, test.want)
+			}
+		})
+	}
+}
+
+func TestMultiplier(t *testing.T) {
+	tests := []struct {
+		combo     int
+		starPower bool
+		want      float64
+	}{
+		{0, false, 1},
+		{ComboStep - 1, false, 1},
+		{ComboStep, false, 2},
+		{3*ComboStep - 1, false, 3},
+		{3 * ComboStep, false, 4},
+		{100 * ComboStep, false, float64(MaxMultiplier)},
+		{0, true, 2},
+		{3 * ComboStep, true, 8},
+	}
+	for _, test := range tests {
+		m := newTestGame()
+		m.combo = test.combo
+		m.starPowerActive = test.starPower
+		if got := m.multiplier(); got != test.want {
+			t.Errorf("multiplier() with a combo of %d and star power %v = %v, want %v", test.combo, test.starPower, got, test.want)
+		}
+	}
+}
+
+func TestComboReset(t *testing.T) {
+	tests := []struct {
+		name string
+		// plays against a chord on fret 2 at time 1
+		play      func(m *Game, chord *chordState)
+		wantCombo int
+		// score the play added
+		wantScore float64
+	}{
+		{"hit", func(m *Game, chord *chordState) {
+			m.held[2] = true
+			m.strumming = true
+			m.judgeInput(chord.time)
+		}, ComboStep + 1, Judgements[0].Score * 2},
+		{"miss", func(m *Game, chord *chordState) {
+			m.judgeInput(chord.time + missWindow() + 0.01)
+			m.missChord(chord, missWindow()+0.01)
+		}, 0, 0},
+		{"late strum", func(m *Game, chord *chordState) {
+			m.held[2] = true
+			m.strumming = true
+			m.judgeInput(chord.time + missWindow() - 0.01)
+		}, 0, 0},
+		{"wrong frets", func(m *Game, chord *chordState) {
+			m.held[1] = true
+			m.strumming = true
+			m.judgeInput(chord.time)
+		}, 0, 0},
+		{"overstrum", func(m *Game, chord *chordState) {
+			m.held[2] = true
+			m.strumming = true
+			m.judgeInput(chord.time - 1)
+		}, 0, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newTestGame()
+			chord := &chordState{time: 1}
+			chord.frets[2] = true
+			m.notes[2] = []NotePos{{position: float64(NoteSpawn), chord: chord}}
+			m.combo = ComboStep
+			m.maxCombo = ComboStep
+
+			test.play(&m, chord)
+			if m.combo != test.wantCombo {
+				t.Errorf("combo = %d, want %d", m.combo, test.wantCombo)
+			}
+			if m.maxCombo != max(ComboStep, test.wantCombo) {
+				t.Errorf("max combo = %d, want %d", m.maxCombo, max(ComboStep, test.wantCombo))
+			}
+			if m.score != test.wantScore {
+				t.Errorf("score = %v, want %v", m.score, test.wantScore)
+			}
+		})
+	}
+}
+
+// runs the commands, giving the stopwatch the messages meant for it
+func runStopwatch(m *Game, cmd tea.Cmd) {
+	if cmd == nil {
+		return
+	}
+	msg := cmd()
+	// batches and sequences are slices of commands
+	if v := reflect.ValueOf(msg); v.Kind() == reflect.Slice {
+		for i := range v.Len() {
+			runStopwatch(m, v.Index(i).Interface().(tea.Cmd))
+		}
+		return
+	}
+	if _, ok := msg.(stopwatch.StartStopMsg); ok {
+		m.stopwatch, _ = m.stopwatch.Update(msg)
+	}
+}
+
+func TestPauseFreezesClock(t *testing.T) {
+	tests := []struct {
+		name string
+		// what happens between the two looks at the clock
+		act         func(m *Game)
+		wantMoving  bool
+		wantRunning bool
+	}{
+		{"playing", func(m *Game) {}, true, true},
+		{"paused", func(m *Game) { runStopwatch(m, m.pause()) }, false, false},
+		{"resumed", func(m *Game) {
+			runStopwatch(m, m.pause())
+			runStopwatch(m, m.resume())
+		}, true, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newTestGame()
+			m.noteSpeed = NoteSpeed
+			m.song = Song{Chart: &gotar_hero.Chart{}}
+			m.stopwatch = stopwatch.New()
+			// a song without stems, which only has the silent clock
+			m.audio = m.mixer.PlaySong(map[string]string{}, 1.0, 0)
+			defer m.audio.Stop()
+			runStopwatch(&m, m.stopwatch.Start())
+
+			// mixing is what moves the clock on, so mix past the stream's latency
+			mix := func() {
+				for range 100 {
+					m.mixer.FillBuffers()
+				}
+			}
+			mix()
+			before := m.clock()
+			test.act(&m)
+			mix()
+			after := m.clock()
+
+			if moving := after > before; moving != test.wantMoving {
+				t.Errorf("clock went from %v to %v, want moving %v", before, after, test.wantMoving)
+			}
+			if m.paused == test.wantMoving {
+				t.Errorf("paused = %v, want %v", m.paused, !test.wantMoving)
+			}
+			if m.stopwatch.Running() != test.wantRunning {
+				t.Errorf("stopwatch running = %v, want %v", m.stopwatch.Running(), test.wantRunning)
+			}
+		})
+	}
+}
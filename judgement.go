@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"time"
+
+	"github.com/charmbracelet/lipgloss/v2"
+)
+
+type Judgement struct {
+	Name string
+	// the largest timing error this judgement is given for
+	Window time.Duration
+	// score per note, multiplied by the current multiplier
+	Score float64
+	Color color.Color
+}
+
+// Judgements from best to worst. A hit is given the first judgement whose window contains its
+// timing error, the last one is the miss which notes get once they are too late to be hit. The
+// windows and scores are set from the config.
+var Judgements = []Judgement{
+	{"Perfect", 35 * time.Millisecond, 100, lipgloss.Color("#a3e635")},
+	{"Great", 70 * time.Millisecond, 70, lipgloss.Color("#138ed2")},
+	{"Good", 110 * time.Millisecond, 40, lipgloss.Color("#cab50c")},
+	{"Miss", 150 * time.Millisecond, 0, lipgloss.Color("#b72528")},
+}
+
+// shown when strumming without a note to hit, this is not a window
+var overstrum = Judgement{"Overstrum", 0, 0, lipgloss.Color("#b72528")}
+
+// how long a judgement stays on screen, in seconds
+const judgementFlash = 0.5
+
+// returns the judgement for a timing error in seconds
+func judge(offset float64) Judgement {
+	for _, judgement := range Judgements {
+		if math.Abs(offset) <= judgement.Window.Seconds() {
+			return judgement
+		}
+	}
+	return missJudgement()
+}
+
+// reports whether a strum with the timing error in seconds is before every scoring window. It is
+// an overstrum rather than a miss, so the note can still be hit on time.
+func tooEarly(offset float64) bool {
+	return offset < 0 && judge(offset).Name == missJudgement().Name
+}
+
+func missJudgement() Judgement {
+	return Judgements[len(Judgements)-1]
+}
+
+// notes further than this from the strum can't be hit anymore
+func missWindow() float64 {
+	return missJudgement().Window.Seconds()
+}
+
+func renderJudgement(judgement Judgement, offset float64) string {
+	text := judgement.Name
+	if judgement.Window != 0 && offset != 0 {
+		text += fmt.Sprintf(" %+dms", int(math.Round(offset*1000)))
+	}
+	return lipgloss.NewStyle().Foreground(judgement.Color).Bold(true).Render(text)
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestJudge(t *testing.T) {
+	tests := []struct {
+		offset float64
+		want   string
+		early  bool
+	}{
+		{0, "Perfect", false},
+		{-0.035, "Perfect", false},
+		{0.05, "Great", false},
+		{-0.1, "Good", false},
+		{0.12, "Miss", false},
+		{-0.12, "Miss", true},
+		{-0.5, "Miss", true},
+	}
+	for _, test := range tests {
+		if got := judge(test.offset); got.Name != test.want {
+			t.Errorf("judge(%v) = %v, want %v", test.offset, got.Name, test.want)
+		}
+		if got := tooEarly(test.offset); got != test.early {
+			t.Errorf("tooEarly(%v) = %v, want %v", test.offset, got, test.early)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea/v2"
+	"github.com/charmbracelet/lipgloss/v2"
+	"github.com/charmbracelet/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+// file the leaderboard database is kept in
+var LeaderboardPath = "leaderboard.db"
+
+// scores shown per song and difficulty
+const leaderboardRows = 10
+
+// a completed play of a song
+type Run struct {
+	// SHA256 fingerprint of the player's SSH public key
+	Fingerprint string    `json:"fingerprint"`
+	User        string    `json:"user"`
+	Song        string    `json:"song"`
+	Difficulty  string    `json:"difficulty"`
+	Score       int       `json:"score"`
+	Accuracy    float64   `json:"accuracy"`
+	MaxCombo    int       `json:"max_combo"`
+	Time        time.Time `json:"time"`
+}
+
+// stores every run in a bolt database, with one bucket per song and difficulty
+type Leaderboard struct {
+	db *bolt.DB
+}
+
+func OpenLeaderboard(path string) (*Leaderboard, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open leaderboard: %w", err)
+	}
+	return &Leaderboard{db}, nil
+}
+
+func (l *Leaderboard) Close() error {
+	return l.db.Close()
+}
+
+func leaderboardBucket(song string, difficulty string) []byte {
+	return []byte(song + "\x00" + difficulty)
+}
+
+func (l *Leaderboard) Record(run Run) error {
+	return l.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(leaderboardBucket(run.Song, run.Difficulty))
+		if err != nil {
+			return err
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(run)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(binary.BigEndian.AppendUint64(nil, id), value)
+	})
+}
+
+// returns the best n runs of a song on a difficulty, highest score first
+func (l *Leaderboard) Top(song string, difficulty string, n int) ([]Run, error) {
+	runs := []Run{}
+	err := l.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(leaderboardBucket(song, difficulty))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var run Run
+			if err := json.Unmarshal(v, &run); err != nil {
+				return err
+			}
+			runs = append(runs, run)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortStableFunc(runs, func(a, b Run) int {
+		return b.Score - a.Score
+	})
+	return runs[:min(n, len(runs))], nil
+}
+
+type LeaderboardScreen struct {
+	width  int
+	height int
+	menu   Menu
+	// index of the shown song in the library and track in its chart
+	song  int
+	track int
+	runs  []Run
+}
+
+func NewLeaderboardScreen(menu Menu) LeaderboardScreen {
+	m := LeaderboardScreen{width: menu.width, height: menu.height, menu: menu}
+	m.load()
+	return m
+}
+
+func (m LeaderboardScreen) Init() tea.Cmd {
+	return nil
+}
+
+func (m LeaderboardScreen) currentSong() (Song, bool) {
+	if m.song >= len(m.menu.library.Songs) {
+		return Song{}, false
+	}
+	return m.menu.library.Songs[m.song], true
+}
+
+func (m *LeaderboardScreen) load() {
+	m.runs = nil
+	song, ok := m.currentSong()
+	if !ok || m.track >= len(song.Chart.Tracks) {
+		return
+	}
+	runs, err := m.menu.leaderboard.Top(song.ID, song.Chart.Tracks[m.track].Name, leaderboardRows)
+	if err != nil {
+		log.Error("failed to load leaderboard", "song", song.ID, "error", err)
+		return
+	}
+	m.runs = runs
+}
+
+func (m LeaderboardScreen) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if cmd, ok := m.menu.updateShared(msg, &m.width, &m.height); ok {
+		return m, cmd
+	}
+	switch msg := msg.(type) {
+	case tea.KeyPressMsg:
+		songs := len(m.menu.library.Songs)
+		switch msg.String() {
+		case "right", "l":
+			if songs > 0 {
+				m.song = mod(m.song+1, songs)
+				m.track = 0
+				m.load()
+			}
+		case "left", "h":
+			if songs > 0 {
+				m.song = mod(m.song-1, songs)
+				m.track = 0
+				m.load()
+			}
+		case "down", "j":
+			if song, ok := m.currentSong(); ok {
+				m.track = min(m.track+1, max(0, len(song.Chart.Tracks)-1))
+				m.load()
+			}
+		case "up", "k":
+			m.track = max(m.track-1, 0)
+			m.load()
+		case "q", "esc":
+			return m.menu.resume()
+		}
+	}
+	return m, nil
+}
+
+func (m LeaderboardScreen) View() tea.View {
+	song, ok := m.currentSong()
+	var result string
+	if !ok {
+		result = lipgloss.NewStyle().Foreground(subtle).Render("No songs found in " + SongsDir)
+	} else {
+		title := lipgloss.NewStyle().Foreground(highlight).Bold(true).Render("◂ " + song.Title + " ▸")
+		if song.Artist != "" {
+			title += lipgloss.NewStyle().Foreground(subtle).Render(" by " + song.Artist)
+		}
+
+		difficulties := ""
+		for i, track := range song.Chart.Tracks {
+			style := lipgloss.NewStyle().Foreground(subtle)
+			if i == m.track {
+				style = style.Foreground(highlight).Bold(true)
+			}
+			difficulties = lipgloss.JoinVertical(0, difficulties, style.Render(track.DisplayName()))
+		}
+
+		table := lipgloss.NewStyle().Foreground(subtle).Render(fmt.Sprintf("%-4s %-20s %9s %8s %6s  %s", "#", "Player", "Score", "Accuracy", "Combo", "Date"))
+		for i, run := range m.runs {
+			style := lipgloss.NewStyle().Foreground(normal)
+			// the current player's runs stand out
+			if run.Fingerprint != "" && run.Fingerprint == m.menu.profile.Fingerprint {
+				style = style.Foreground(highlight).Bold(true)
+			}
+			line := fmt.Sprintf("%-4d %-20.20s %9d %7.1f%% %6d  %s", i+1, run.User, run.Score, run.Accuracy, run.MaxCombo, run.Time.Format("2006-01-02"))
+			table = lipgloss.JoinVertical(0, table, style.Render(line))
+		}
+		if len(m.runs) == 0 {
+			table = lipgloss.JoinVertical(0, table, lipgloss.NewStyle().Foreground(subtle).Render("No scores yet"))
+		}
+
+		box := lipgloss.NewStyle().Border(lipgloss.NormalBorder()).BorderForeground(subtle).Padding(1, 2)
+		result = lipgloss.JoinVertical(0,
+			title,
+			"",
+			lipgloss.JoinHorizontal(0,
+				box.Render(difficulties),
+				"  ",
+				box.Height(leaderboardRows+1).Render(table),
+			),
+			lipgloss.NewStyle().Foreground(subtle).Render("←/→ song • ↑/↓ difficulty • esc back"),
+		)
+	}
+	result = lipgloss.Place(m.width, m.height, 0.5, 0.5, result)
+	view := tea.NewView(result)
+	view.KeyReleases = true
+	return view
+}
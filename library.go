@@ -0,0 +1,160 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	gotar_hero "github.com/mbund/terminal-hero/pkg/gotar-hero"
+)
+
+// directory which is scanned for song folders
+var SongsDir = "songs"
+
+// stems songs split their audio into, as named by Clone Hero. The backing track is "song".
+var songStems = []string{"song", "guitar", "rhythm", "bass", "keys", "drums", "drums_1", "drums_2", "drums_3", "drums_4", "vocals", "crowd"}
+
+// extensions tried in order for each stem. Raw files are S16_LE stereo 44.1 kHz, the rest are
+// decoded and resampled when they play.
+var songAudioExtensions = []string{".ogg", ".opus", ".mp3", ".wav", ".raw"}
+
+// the stem of the instrument the player plays, which is ducked when they miss
+var instrumentStems = map[gotar_hero.Instrument]string{
+	gotar_hero.InstrumentSingle:       "guitar",
+	gotar_hero.InstrumentDoubleGuitar: "guitar",
+	gotar_hero.InstrumentDoubleBass:   "bass",
+	gotar_hero.InstrumentDoubleRhythm: "rhythm",
+	gotar_hero.InstrumentDrums:        "drums",
+	gotar_hero.InstrumentKeyboard:     "keys",
+	gotar_hero.InstrumentGHLGuitar:    "guitar",
+	gotar_hero.InstrumentGHLBass:      "bass",
+}
+
+type Song struct {
+	// path of the song folder relative to the library, which identifies the song
+	ID string
+	// folder the song was loaded from
+	Dir     string
+	Title   string
+	Artist  string
+	Album   string
+	Genre   string
+	Year    string
+	Charter string
+	// length of the song in seconds
+	Length float64
+	// audio files to play along with the chart by stem, empty if the folder has none
+	Stems map[string]string
+	Chart *gotar_hero.Chart
+}
+
+type Library struct {
+	Songs []Song
+}
+
+func (library *Library) Song(id string) (Song, bool) {
+	for _, song := range library.Songs {
+		if song.ID == id {
+			return song, true
+		}
+	}
+	return Song{}, false
+}
+
+// scans dir for song folders, which contain a notes.chart and the song's audio like Clone Hero
+// songs do. Folders which fail to load are logged and skipped.
+func LoadLibrary(dir string) (*Library, error) {
+	library := &Library{Songs: []Song{}}
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || entry.Name() != "notes.chart" {
+			return nil
+		}
+		song, err := LoadSong(filepath.Dir(path))
+		if err != nil {
+			log.Warn("skipping song", "dir", filepath.Dir(path), "error", err)
+			return nil
+		}
+		song.ID, err = filepath.Rel(dir, song.Dir)
+		if err != nil {
+			return err
+		}
+		library.Songs = append(library.Songs, *song)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(library.Songs, func(a, b Song) int {
+		if c := strings.Compare(strings.ToLower(a.Artist), strings.ToLower(b.Artist)); c != 0 {
+			return c
+		}
+		return strings.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title))
+	})
+	log.Info("loaded song library", "dir", dir, "songs", len(library.Songs))
+	return library, nil
+}
+
+func LoadSong(dir string) (*Song, error) {
+	chart, err := gotar_hero.OpenChart(filepath.Join(dir, "notes.chart"))
+	if err != nil {
+		return nil, err
+	}
+
+	song := &Song{
+		Dir:     dir,
+		Title:   chart.Title,
+		Artist:  chart.Artist,
+		Album:   chart.Album,
+		Genre:   chart.Genre,
+		Year:    strings.TrimPrefix(chart.Year, ", "),
+		Charter: chart.Charter,
+		Length:  chart.Length,
+		Chart:   chart,
+	}
+	if song.Title == "" {
+		song.Title = filepath.Base(dir)
+	}
+	// most charts leave the length out, so use the end of the last note instead
+	if song.Length == 0 {
+		song.Length = chart.LastNoteTime()
+	}
+
+	song.Stems = findStems(dir, chart.MusicStream)
+	if len(song.Stems) == 0 {
+		log.Warn("song has no audio", "dir", dir)
+	}
+
+	return song, nil
+}
+
+// finds the audio of each stem in the song folder. The chart's music stream is the backing
+// track, and older songs keep their backing track in audio.raw.
+func findStems(dir string, musicStream string) map[string]string {
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(dir, name))
+		return name != "" && err == nil
+	}
+
+	stems := map[string]string{}
+	for _, stem := range songStems {
+		for _, ext := range songAudioExtensions {
+			if exists(stem + ext) {
+				stems[stem] = filepath.Join(dir, stem+ext)
+				break
+			}
+		}
+	}
+	if exists(musicStream) {
+		stems["song"] = filepath.Join(dir, musicStream)
+	} else if _, ok := stems["song"]; !ok && exists("audio.raw") {
+		stems["song"] = filepath.Join(dir, "audio.raw")
+	}
+	return stems
+}
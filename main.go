@@ -5,14 +5,14 @@ package main
 
 import (
 	"context"
-	"encoding/base64"
 	"errors"
+	"flag"
 	"fmt"
 
 	"net"
 	"os"
 	"os/signal"
-	"sync"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -23,110 +23,126 @@ import (
 	"github.com/charmbracelet/wish/v2"
 	"github.com/charmbracelet/wish/v2/bubbletea"
 	"github.com/charmbracelet/wish/v2/logging"
+	gossh "golang.org/x/crypto/ssh"
 )
 
-const (
-	host = "0.0.0.0"
-	port = "23234"
-)
-
-type associationTuple struct {
-	pubkey        string
-	user          string
-	ip            string
-	clientVersion string
-}
-
-func PublicKeyToAuthString(pubKey ssh.PublicKey) string {
-	keyBytes := base64.StdEncoding.EncodeToString(pubKey.Marshal())
-
-	return fmt.Sprintf("%s %s", pubKey.Type(), keyBytes)
-}
-
-func newAssociationTuple(sess ssh.Session) associationTuple {
-	return associationTuple{
-		pubkey:        PublicKeyToAuthString(sess.PublicKey()),
-		user:          sess.User(),
-		ip:            sess.RemoteAddr().(*net.TCPAddr).IP.String(),
-		clientVersion: sess.Context().ClientVersion(),
+// returns the SHA256 fingerprint of the session's public key, or an empty string without one
+func PublicKeyFingerprint(sess ssh.Session) string {
+	if sess.PublicKey() == nil {
+		return ""
 	}
-}
-
-type sessionData struct {
-	mixer     *AudioMixer
-	connected chan bool
-}
-
-var (
-	associations   map[associationTuple]*sessionData = make(map[associationTuple]*sessionData)
-	associationsMu sync.RWMutex
-)
-
-func getOrCreateSessionData(tuple associationTuple) *sessionData {
-	associationsMu.Lock()
-	defer associationsMu.Unlock()
-
-	if data, exists := associations[tuple]; exists {
-		return data
-	}
-
-	const channels = 2
-	const mixAmp = 1.0
-	const framesPerWrite = 128
-	const sampleRate = 44100
-	const bytesPerSample = 2
-
-	data := &sessionData{
-		mixer:     NewAudioMixer(channels, mixAmp, framesPerWrite, sampleRate, bytesPerSample),
-		connected: make(chan bool, 1),
-	}
-	associations[tuple] = data
-	return data
+	return gossh.FingerprintSHA256(sess.PublicKey())
 }
 
 func AudioMiddleware() wish.Middleware {
 	return func(next ssh.Handler) ssh.Handler {
 		return func(sess ssh.Session) {
 			_, _, active := sess.Pty()
-			tuple := newAssociationTuple(sess)
-			sessionData := getOrCreateSessionData(tuple)
-			sess.Context().SetValue("sessionData", sessionData)
 			if active {
+				data, status, err := sessions.joinGame(PublicKeyFingerprint(sess))
+				if err != nil {
+					log.Error("failed to create session", "error", err)
+					_ = sess.Exit(1)
+					return
+				}
+				defer sessions.leaveGame(data)
+				sess.Context().SetValue("sessionData", data)
+				sess.Context().SetValue("status", status)
 				next(sess)
 				return
 			}
 
-			if sessionData == nil || sessionData.mixer == nil {
-				log.Error("failed to get session data")
+			command := sess.Command()
+			flags := flag.NewFlagSet("pair", flag.ContinueOnError)
+			flags.SetOutput(sess.Stderr())
+			format := flags.String("format", "raw", "encoding of the audio stream: raw, wav, mulaw, flac or ogg")
+			if len(command) < 2 || command[0] != "pair" || flags.Parse(command[2:]) != nil {
+				fmt.Fprintln(sess.Stderr(), "usage: ssh -T <host> pair <code> [--format raw|wav|mulaw|flac|ogg], with the code shown in the game")
+				_ = sess.Exit(1)
+				return
+			}
+			encoding, err := FindAudioEncoding(*format)
+			if err != nil {
+				fmt.Fprintln(sess.Stderr(), err)
+				_ = sess.Exit(1)
+				return
+			}
+			host, _, err := net.SplitHostPort(sess.RemoteAddr().String())
+			if err != nil {
+				host = sess.RemoteAddr().String()
+			}
+			data, err := sessions.joinAudio(command[1], host, PublicKeyFingerprint(sess))
+			if err != nil {
+				log.Warn("failed to pair audio", "user", sess.User(), "code", command[1], "error", err)
+				fmt.Fprintln(sess.Stderr(), err)
 				_ = sess.Exit(1)
 				return
 			}
-			sessionData.connected <- true
-			sendAudio(sess, sessionData.mixer)
-			sessionData.connected <- false
+			log.Info("streaming audio", "code", data.Code(), "format", encoding.Name)
+			sendAudio(sess, data.mixer, encoding, data.done)
+			sessions.leaveAudio(data)
 			_ = sess.Exit(0)
 		}
 	}
 }
 
-func CleanupMiddleware() wish.Middleware {
-	return func(next ssh.Handler) ssh.Handler {
-		return func(sess ssh.Session) {
-			log.Info("cleanup")
-			next(sess)
-		}
-	}
-}
+var (
+	config      *Config
+	library     *Library
+	leaderboard *Leaderboard
+	profiles    *Profiles
+)
 
 func main() {
+	var err error
+	config, err = LoadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal("Invalid configuration", "error", err)
+	}
+	SongsDir = config.Paths.Songs
+	LeaderboardPath = config.Paths.Leaderboard
+	ProfilesPath = config.Paths.Profiles
+	MissSound = config.Paths.MissSound
+	NoteSpawn = config.Game.NoteSpawn
+	NoteSpeed = config.Game.NoteSpeed
+	MissVolume = config.Game.MissVolume
+	Judgements[0].Window, Judgements[0].Score = config.Game.PerfectWindow, config.Game.PerfectScore
+	Judgements[1].Window, Judgements[1].Score = config.Game.GreatWindow, config.Game.GreatScore
+	Judgements[2].Window, Judgements[2].Score = config.Game.GoodWindow, config.Game.GoodScore
+	Judgements[3].Window = config.Game.MissWindow
+	PairTimeout = config.Server.PairTimeout
+	SessionGrace = config.Server.SessionGrace
+	AudioLatency = config.Audio.Latency
+
+	auth, err := NewAuthPolicy(config.Auth.Mode, config.Auth.AuthorizedKeys, config.Auth.BannedKeys, config.Auth.Guests)
+	if err != nil {
+		log.Fatal("Invalid auth policy", "error", err)
+	}
+
+	library, err = LoadLibrary(SongsDir)
+	if err != nil {
+		log.Fatal("Could not load song library", "dir", SongsDir, "error", err)
+	}
+	leaderboard, err = OpenLeaderboard(LeaderboardPath)
+	if err != nil {
+		log.Fatal("Could not open leaderboard", "path", LeaderboardPath, "error", err)
+	}
+	defer leaderboard.Close()
+	profiles, err = OpenProfiles(ProfilesPath)
+	if err != nil {
+		log.Fatal("Could not open profiles", "path", ProfilesPath, "error", err)
+	}
+	defer profiles.Close()
+
 	s, err := wish.NewServer(
-		wish.WithAddress(net.JoinHostPort(host, port)),
-		wish.WithHostKeyPath(".ssh/id_ed25519"),
-		wish.WithPublicKeyAuth(func(ctx ssh.Context, key ssh.PublicKey) bool {
-			return true
-		}),
+		wish.WithAddress(net.JoinHostPort(config.Server.Host, strconv.Itoa(config.Server.Port))),
+		wish.WithHostKeyPath(config.Server.HostKey),
+		wish.WithPublicKeyAuth(auth.PublicKeyHandler),
+		wish.WithKeyboardInteractiveAuth(auth.KeyboardInteractiveHandler),
 		wish.WithMiddleware(
-			CleanupMiddleware(),
 			bubbletea.Middleware(teaHandler),
 			AudioMiddleware(),
 			logging.Middleware(),
@@ -138,7 +154,7 @@ func main() {
 
 	done := make(chan os.Signal, 1)
 	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
-	log.Info("Starting SSH server", "host", host, "port", port)
+	log.Info("Starting SSH server", "host", config.Server.Host, "port", config.Server.Port, "auth", auth.Mode, "guests", auth.Guests)
 	go func() {
 		if err = s.ListenAndServe(); err != nil && !errors.Is(err, ssh.ErrServerClosed) {
 			log.Error("Could not start server", "error", err)
@@ -159,6 +175,17 @@ func teaHandler(s ssh.Session) (tea.Model, []tea.ProgramOption) {
 	pty, _, _ := s.Pty()
 
 	sessionData := s.Context().Value("sessionData").(*sessionData)
+	status := s.Context().Value("status").(<-chan bool)
+
+	name := s.User()
+	if guest := GuestName(s); guest != "" {
+		name = guest
+	}
+	profile, err := profiles.LoadOrCreate(PublicKeyFingerprint(s), name)
+	if err != nil {
+		log.Error("failed to load profile", "user", name, "error", err)
+		profile = &Profile{Name: name}
+	}
 
 	sp := spinner.New()
 	sp.Spinner = spinner.Dot
@@ -168,7 +195,13 @@ func teaHandler(s ssh.Session) (tea.Model, []tea.ProgramOption) {
 		height:      pty.Window.Height,
 		mixer:       sessionData.mixer,
 		sessionData: sessionData,
+		status:      status,
 		spinner:     sp,
+		library:     library,
+		leaderboard: leaderboard,
+		profiles:    profiles,
+		profile:     profile,
+		target:      newSSHTarget(s, config.Server),
 	}
 
 	return m, []tea.ProgramOption{}
@@ -2,14 +2,12 @@ package main
 
 import (
 	"strings"
-	"time"
 
 	"github.com/charmbracelet/bubbles/v2/spinner"
-	"github.com/charmbracelet/bubbles/v2/stopwatch"
-	gotar_hero "github.com/mbund/terminal-hero/pkg/gotar-hero"
 
 	tea "github.com/charmbracelet/bubbletea/v2"
 	"github.com/charmbracelet/lipgloss/v2"
+	"github.com/charmbracelet/log"
 )
 
 var (
@@ -25,12 +23,51 @@ type Menu struct {
 	connected   bool
 	mixer       *AudioMixer
 	sessionData *sessionData
+	// reports the session's audio connection coming and going
+	status      <-chan bool
 	spinner     spinner.Model
+	library     *Library
+	leaderboard *Leaderboard
+	profiles    *Profiles
+	// shared by every screen of the session, so changes are seen everywhere
+	profile *Profile
+	// where the audio command points to
+	target sshTarget
+}
+
+// saves the player's profile, logging rather than interrupting the screen if it fails
+func (m Menu) saveProfile() {
+	if err := m.profiles.Save(*m.profile); err != nil {
+		log.Error("failed to save profile", "error", err)
+	}
+}
+
+// keeps a screen and the menu it goes back to up to date with the audio connection and the
+// window size, reporting whether msg was one of those
+func (m *Menu) updateShared(msg tea.Msg, width, height *int) (tea.Cmd, bool) {
+	switch msg := msg.(type) {
+	case connectionMsg:
+		m.connected = msg.connected
+		return connectionStatus(m.status), true
+	case tea.WindowSizeMsg:
+		*width = msg.Width
+		*height = msg.Height
+		m.width = msg.Width
+		m.height = msg.Height
+		return nil, true
+	}
+	return nil, false
+}
+
+// picks the menu back up after another screen, which kept the connection status up to date
+func (m Menu) resume() (tea.Model, tea.Cmd) {
+	// the spinner stopped ticking while the menu was not shown
+	return m, m.spinner.Tick
 }
 
 func (m Menu) Init() tea.Cmd {
 	return tea.Batch(
-		connectionStatus(m.sessionData.connected),
+		connectionStatus(m.status),
 		m.spinner.Tick,
 	)
 }
@@ -38,11 +75,16 @@ func (m Menu) Init() tea.Cmd {
 const (
 	BUTTON_PLAY = iota
 	BUTTON_LEADERBOARD
+	BUTTON_PROFILE
+	BUTTON_CALIBRATE
 	BUTTON_QUIT
 	BUTTON_MAX = iota - 1
 )
 
 func (m Menu) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if cmd, ok := m.updateShared(msg, &m.width, &m.height); ok {
+		return m, cmd
+	}
 	switch msg := msg.(type) {
 	case tea.KeyPressMsg:
 		switch msg.String() {
@@ -50,30 +92,42 @@ func (m Menu) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.selected = min(m.selected+1, BUTTON_MAX)
 		case "up", "k":
 			m.selected = max(m.selected-1, 0)
+		case "tab":
+			player := AudioPlayers[mod(audioPlayerIndex(m.profile.Settings.AudioPlayer)+1, len(AudioPlayers))]
+			m.profile.Settings.AudioPlayer = player.Name
+			m.saveProfile()
+		case "f":
+			encoding := AudioEncodings[mod(audioEncodingIndex(m.profile.Settings.AudioEncoding)+1, len(AudioEncodings))]
+			m.profile.Settings.AudioEncoding = encoding.Name
+			m.saveProfile()
+		case "r":
+			if m.sessionData.Code() == "" {
+				if _, err := sessions.register(m.sessionData); err != nil {
+					log.Error("failed to register session", "error", err)
+				}
+			}
 		case "space", "enter":
 			switch m.selected {
 			case BUTTON_QUIT:
 				return m, tea.Quit
 			case BUTTON_PLAY:
-				chart, err := gotar_hero.OpenChart("notes.chart")
-				if err != nil {
-					panic(err)
-				}
-				cursor, _ := gotar_hero.NewChartCursor(*chart, "EasySingle")
-				game := Game{width: m.width, height: m.height, stopwatch: stopwatch.New(stopwatch.WithInterval(10 * time.Millisecond)), mixer: m.mixer, held: make([]bool, 5), notes: make([][]NotePos, 5), cursor: *cursor}
-				return game, game.Init()
+				songSelect := NewSongSelect(m)
+				return songSelect, songSelect.Init()
+			case BUTTON_LEADERBOARD:
+				leaderboard := NewLeaderboardScreen(m)
+				return leaderboard, leaderboard.Init()
+			case BUTTON_PROFILE:
+				profile := NewProfileScreen(m)
+				return profile, profile.Init()
+			case BUTTON_CALIBRATE:
+				calibration := NewCalibrationScreen(m)
+				return calibration, calibration.Init()
 			}
 		}
-	case connectionMsg:
-		m.connected = msg.connected
-		return m, connectionStatus(m.sessionData.connected)
 	case spinner.TickMsg:
 		var cmd tea.Cmd
 		m.spinner, cmd = m.spinner.Update(msg)
 		return m, cmd
-	case tea.WindowSizeMsg:
-		m.width = msg.Width
-		m.height = msg.Height
 	}
 	return m, nil
 }
@@ -125,6 +179,10 @@ func (m Menu) View() tea.View {
 			button = "Play"
 		case BUTTON_LEADERBOARD:
 			button = "Leaderboard"
+		case BUTTON_PROFILE:
+			button = "Profile: " + m.profile.Name
+		case BUTTON_CALIBRATE:
+			button = "Calibrate"
 		case BUTTON_QUIT:
 			button = "Quit"
 		}
@@ -138,14 +196,43 @@ func (m Menu) View() tea.View {
 		menu = lipgloss.JoinVertical(0.0, menu, lipgloss.NewStyle().Foreground(color).Bold(true).Border(lipgloss.NormalBorder()).BorderForeground(color).Padding(1).PaddingLeft(2).Width(54).Render(button))
 	}
 
-	connectionCommand := "ssh -T -p 23234 -o UserKnownHostsFile=/dev/null -o StrictHostKeyChecking=no 18.118.13.6  | aplay -f S16_LE -c 2 -r 44100 --buffer-size 1024"
+	current := audioPlayerIndex(m.profile.Settings.AudioPlayer)
+	players := ""
+	for i, player := range AudioPlayers {
+		style := lipgloss.NewStyle().Foreground(subtle)
+		if i == current {
+			style = style.Foreground(highlight).Bold(true)
+		}
+		players += style.Render(player.Name) + "  "
+	}
+	currentEncoding := audioEncodingIndex(m.profile.Settings.AudioEncoding)
+	encodings := ""
+	for i, encoding := range AudioEncodings {
+		style := lipgloss.NewStyle().Foreground(subtle)
+		if i == currentEncoding {
+			style = style.Foreground(highlight).Bold(true)
+		}
+		encodings += style.Render(encoding.Name) + "  "
+	}
+	code := m.sessionData.Code()
+	connectionCommand, err := m.target.audioCommand(AudioPlayers[current], AudioEncodings[currentEncoding], m.mixer.Format(), code)
+	if err != nil {
+		connectionCommand = err.Error()
+	}
+	if code == "" {
+		connectionCommand = "The pairing code expired, press r for a new one"
+	}
 	connectionBlock := AddTitle(lipgloss.NewStyle().Foreground(normal).Border(lipgloss.NormalBorder()).Padding(1, 2).Width(170).Render(connectionCommand), "Connect to audio:")
+	connectionBlock = lipgloss.JoinVertical(0.5, connectionBlock,
+		"Player (tab): "+players+"   Format (f): "+encodings,
+		AudioEncodings[currentEncoding].Description,
+	)
 
 	var connectionStatus string
 	if m.connected {
 		connectionStatus = lipgloss.NewStyle().Foreground(highlight).Bold(true).Render("🎶Connected")
-	} else {
-		connectionStatus = lipgloss.NewStyle().Foreground(highlight).Bold(true).Render(m.spinner.View() + " Waiting for audio connection...")
+	} else if code != "" {
+		connectionStatus = lipgloss.NewStyle().Foreground(highlight).Bold(true).Render(m.spinner.View() + " Waiting for audio connection with code " + code + "...")
 	}
 
 	result := lipgloss.JoinVertical(0.5,
@@ -173,7 +260,11 @@ type connectionMsg struct {
 
 func connectionStatus(ch <-chan bool) tea.Cmd {
 	return func() tea.Msg {
-		value := <-ch
+		value, ok := <-ch
+		// the game connection closed
+		if !ok {
+			return nil
+		}
 		return connectionMsg{connected: value}
 	}
 }
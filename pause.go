@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea/v2"
+	"github.com/charmbracelet/lipgloss/v2"
+	"github.com/charmbracelet/log"
+)
+
+const (
+	PAUSE_RESUME = iota
+	PAUSE_RESTART
+	PAUSE_SETTINGS
+	PAUSE_QUIT
+	PAUSE_MAX = iota - 1
+)
+
+const (
+	PAUSE_AUDIO_OFFSET = iota
+	PAUSE_VIDEO_OFFSET
+	PAUSE_SETTINGS_MAX = iota - 1
+)
+
+// how long the countdown before the song carries on lasts
+const resumeCountdown = 3 * time.Second
+
+// redraws the pause menu while the stopwatch, which usually does, is stopped
+type pauseTickMsg struct {
+	pause int
+}
+
+// freezes the song. The clock comes from the mixer, so pausing it stops the notes and the cursor
+// on the same sample as the audio.
+func (m *Game) pause() tea.Cmd {
+	log.Info("paused", "song", m.song.ID, "time", m.prevTime)
+	m.mixer.Pause()
+	m.paused = true
+	m.pauses++
+	m.pauseSelected = PAUSE_RESUME
+	m.pauseSettings = false
+	m.resumeAt = time.Time{}
+	return tea.Batch(m.stopwatch.Stop(), m.pauseTick())
+}
+
+// carries on once the countdown is over
+func (m *Game) resume() tea.Cmd {
+	log.Info("resumed", "song", m.song.ID, "time", m.prevTime)
+	m.paused = false
+	m.resumeAt = time.Time{}
+	m.mixer.Resume()
+	return m.stopwatch.Start()
+}
+
+func (m Game) pauseTick() tea.Cmd {
+	pause := m.pauses
+	return tea.Tick(50*time.Millisecond, func(time.Time) tea.Msg {
+		return pauseTickMsg{pause}
+	})
+}
+
+// ends the song early, letting the mixer play again for whatever comes next
+func (m *Game) stopSong() {
+	m.audio.Stop()
+	m.mixer.Resume()
+}
+
+func (m Game) updatePause(msg tea.KeyPressMsg) (tea.Model, tea.Cmd) {
+	// only the countdown can be interrupted while it runs, frets and strums wait for the song
+	if !m.resumeAt.IsZero() {
+		if msg.String() == "esc" || msg.String() == "p" {
+			m.resumeAt = time.Time{}
+		}
+		return m, nil
+	}
+
+	if m.pauseSettings {
+		switch msg.String() {
+		case "down", "j":
+			m.pauseSelected = min(m.pauseSelected+1, PAUSE_SETTINGS_MAX)
+		case "up", "k":
+			m.pauseSelected = max(m.pauseSelected-1, 0)
+		case "left", "h", "right", "l":
+			step := offsetStep
+			if msg.String() == "left" || msg.String() == "h" {
+				step = -step
+			}
+			settings := &m.menu.profile.Settings
+			offset := &settings.AudioOffset
+			if m.pauseSelected == PAUSE_VIDEO_OFFSET {
+				offset = &settings.VideoOffset
+			}
+			*offset = min(max(*offset+step, -maxOffset), maxOffset)
+			m.menu.saveProfile()
+			m.audioOffset = float64(settings.AudioOffset) / 1000
+			m.videoOffset = float64(settings.VideoOffset) / 1000
+		case "q", "esc", "enter":
+			m.pauseSettings = false
+			m.pauseSelected = PAUSE_SETTINGS
+		}
+		return m, nil
+	}
+
+	switch msg.String() {
+	case "down", "j":
+		m.pauseSelected = min(m.pauseSelected+1, PAUSE_MAX)
+	case "up", "k":
+		m.pauseSelected = max(m.pauseSelected-1, 0)
+	case "esc", "p":
+		m.resumeAt = time.Now()
+	case "space", "enter":
+		switch m.pauseSelected {
+		case PAUSE_RESUME:
+			m.resumeAt = time.Now()
+		case PAUSE_RESTART:
+			m.stopSong()
+			game, err := NewGame(m.menu, m.song, m.cursor.Track().Name)
+			if err != nil {
+				log.Error("failed to restart game", "error", err)
+				return m.menu.resume()
+			}
+			return game, game.Init()
+		case PAUSE_SETTINGS:
+			m.pauseSettings = true
+			m.pauseSelected = PAUSE_AUDIO_OFFSET
+		case PAUSE_QUIT:
+			m.stopSong()
+			return m.menu.resume()
+		}
+	case "ctrl+c":
+		return m, tea.Quit
+	}
+	return m, nil
+}
+
+// the pause menu, drawn in place of the highway
+func (m Game) renderPause(width, height int) string {
+	item := func(i int, text string) string {
+		color := subtle
+		if i == m.pauseSelected {
+			color = highlight
+		}
+		return lipgloss.NewStyle().Foreground(color).Bold(true).Render(text)
+	}
+
+	var menu string
+	if m.pauseSettings {
+		settings := m.menu.profile.Settings
+		menu = lipgloss.JoinVertical(0.5,
+			lipgloss.NewStyle().Foreground(highlight).Bold(true).Render("Settings"),
+			"",
+			item(PAUSE_AUDIO_OFFSET, fmt.Sprintf("Audio offset  ◂ %d ms ▸", settings.AudioOffset)),
+			item(PAUSE_VIDEO_OFFSET, fmt.Sprintf("Video offset  ◂ %d ms ▸", settings.VideoOffset)),
+			"",
+			lipgloss.NewStyle().Foreground(subtle).Render("←/→ adjust • esc back"),
+		)
+	} else {
+		menu = lipgloss.JoinVertical(0.5,
+			lipgloss.NewStyle().Foreground(highlight).Bold(true).Render("Paused"),
+			"",
+			item(PAUSE_RESUME, "Resume"),
+			item(PAUSE_RESTART, "Restart"),
+			item(PAUSE_SETTINGS, "Settings"),
+			item(PAUSE_QUIT, "Quit to Menu"),
+		)
+	}
+	box := lipgloss.NewStyle().Border(lipgloss.NormalBorder()).BorderForeground(subtle).Padding(1, 4).Render(menu)
+	return lipgloss.Place(width, height, 0.5, 0.5, box)
+}
+
+// the number the countdown is on, 0 unless it is counting down
+func (m Game) countdown() int {
+	if m.resumeAt.IsZero() {
+		return 0
+	}
+	left := resumeCountdown - time.Since(m.resumeAt)
+	return int((left + time.Second - 1) / time.Second)
+}
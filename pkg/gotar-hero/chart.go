@@ -8,6 +8,7 @@ import (
 	"os"
 	"reflect"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -106,7 +107,8 @@ func ParseRaw(input io.Reader) (*UnstructuredChart, error) {
 
 			key = strings.TrimSpace(key)
 
-			item_regex := regexp.MustCompile(`[\d\.]+|("[^"]+")|\w+`)
+			// bare items run up to the next space, so unquoted lyrics keep their joining "-"
+			item_regex := regexp.MustCompile(`("[^"]+")|[^\s"]+`)
 			items := item_regex.FindAllString(value_str, 32)
 
 			var values []any
@@ -157,39 +159,242 @@ type TempoChange struct {
 	tempo float64
 }
 
+const (
+	// N 5 flips a note between strummed and HOPO
+	NoteForced = 5
+	// N 6 turns every note on the tick into a tap note
+	NoteTap = 6
+	// N 7 is an open note, played by strumming without holding a fret
+	NoteOpen = 7
+)
+
 type Note struct {
 	Tick int
 	Typ  int
 	Len  int
+	// hammer-on/pull-off, can be hit without strumming if the previous note was hit
+	HOPO bool
+	// the natural HOPO state of this note was flipped by a forced modifier
+	Forced bool
+	// can be hit without strumming at any time
+	Tap bool
+	// open notes are hit by strumming without any frets held
+	Open bool
+}
+
+type PhraseKind int
+
+const (
+	// Face-off section for player 1
+	PhrasePlayer1 PhraseKind = 0
+	// Face-off section for player 2
+	PhrasePlayer2 PhraseKind = 1
+	// Star power phrase, completing it fills the star power meter
+	PhraseStarPower PhraseKind = 2
+)
+
+// A special phrase covering the notes in [Tick, Tick+Len)
+type SpecialPhrase struct {
+	Tick int
+	Kind PhraseKind
+	Len  int
+}
+
+// Contains reports whether a note on the given tick is part of the phrase
+func (phrase SpecialPhrase) Contains(tick int) bool {
+	return tick >= phrase.Tick && tick < phrase.Tick+phrase.Len
+}
+
+// Returned by NextEvent for the phrases starting on the next tick
+type PhraseStart []SpecialPhrase
+
+// Returned by NextEvent for the phrases ending on the next tick
+type PhraseEnd []SpecialPhrase
+
+type Difficulty int
+
+const (
+	DifficultyEasy Difficulty = iota
+	DifficultyMedium
+	DifficultyHard
+	DifficultyExpert
+)
+
+var difficultyNames = []string{"Easy", "Medium", "Hard", "Expert"}
+
+func (d Difficulty) String() string {
+	return difficultyNames[d]
+}
+
+type Instrument int
+
+const (
+	InstrumentSingle Instrument = iota
+	InstrumentDoubleGuitar
+	InstrumentDoubleBass
+	InstrumentDoubleRhythm
+	InstrumentDrums
+	InstrumentKeyboard
+	InstrumentGHLGuitar
+	InstrumentGHLBass
+	// a track section whose name isn't a known difficulty and instrument
+	InstrumentUnknown
+)
+
+// the instrument part of the track section names, in the same order as the constants
+var instrumentSections = []string{"Single", "DoubleGuitar", "DoubleBass", "DoubleRhythm", "Drums", "Keyboard", "GHLGuitar", "GHLBass"}
+
+var instrumentNames = []string{"Guitar", "Co-op Guitar", "Bass", "Rhythm", "Drums", "Keys", "GHL Guitar", "GHL Bass", "Unknown"}
+
+func (i Instrument) String() string {
+	return instrumentNames[i]
+}
+
+// reports whether N 5, 6 and 7 are the forced, tap and open modifiers on the instrument's
+// tracks. Drums use N 5 for the fifth pad instead.
+func (i Instrument) hasModifiers() bool {
+	switch i {
+	case InstrumentSingle, InstrumentDoubleGuitar, InstrumentDoubleBass, InstrumentDoubleRhythm, InstrumentKeyboard, InstrumentGHLGuitar, InstrumentGHLBass:
+		return true
+	}
+	return false
+}
+
+// the lane of the 5 lane highway a note of the instrument is played on, -1 if it has none
+func (i Instrument) Lane(typ int) int {
+	switch i {
+	case InstrumentDrums:
+		// N 0 is the kick, which has no lane, and N 1 to 5 are the pads from left to right
+		if typ < 1 || typ > 5 {
+			return -1
+		}
+		return typ - 1
+	case InstrumentGHLGuitar, InstrumentGHLBass:
+		// the white frets N 0 to 2 share their column with the black frets N 3, 4 and 8
+		switch typ {
+		case 0, 1, 2:
+			return typ
+		case 3, 4:
+			return typ - 3
+		case 8:
+			return 2
+		}
+		return -1
+	}
+	if typ < 0 || typ > 4 {
+		return -1
+	}
+	return typ
+}
+
+// splits a track section name like "ExpertSingle" into its difficulty and instrument
+func ParseTrackName(name string) (Difficulty, Instrument, bool) {
+	for d := range difficultyNames {
+		rest, found := strings.CutPrefix(name, difficultyNames[d])
+		if !found {
+			continue
+		}
+		for i := range instrumentSections {
+			if rest == instrumentSections[i] {
+				return Difficulty(d), Instrument(i), true
+			}
+		}
+	}
+	return DifficultyEasy, InstrumentUnknown, false
 }
 
 type InstrumentTrack struct {
-	Name  string
-	Notes []Note
+	Name       string
+	Difficulty Difficulty
+	Instrument Instrument
+	Notes      []Note
+	Phrases    []SpecialPhrase
+}
+
+// returns how many notes of the track can be played, which are the open notes and the notes
+// with a lane on the highway
+func (track InstrumentTrack) PlayableNotes() int {
+	count := 0
+	for _, note := range track.Notes {
+		if note.Open || track.Instrument.Lane(note.Typ) >= 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// returns a name for the track like "Expert Guitar"
+func (track InstrumentTrack) DisplayName() string {
+	if track.Instrument == InstrumentUnknown {
+		return track.Name
+	}
+	return track.Difficulty.String() + " " + track.Instrument.String()
+}
+
+type GlobalEventKind int
+
+const (
+	// A named part of the song, like "Verse" or "Chorus"
+	EventSection GlobalEventKind = iota
+	// A single syllable of the vocals
+	EventLyric
+	// Start of a vocal phrase, the lyrics up to the next EventPhraseEnd are sung together
+	EventPhraseStart
+	// End of a vocal phrase
+	EventPhraseEnd
+	// Any other text event
+	EventText
+)
+
+// An event from the [Events] section, which applies to every track
+type GlobalEvent struct {
+	Tick int
+	Kind GlobalEventKind
+	// The section name or lyric syllable, or the whole text for EventText
+	Text string
 }
 
 type Chart struct {
-	Title                string
-	Artist               string
-	Album                string
-	Genre                string
-	Year                 string
-	Charter              string
-	Resolution           int
-	Difficulty           int
-	Length               float64
-	Offset               float64
-	PreviewStart         float64
-	PreviewEnd           float64
+	Title        string
+	Artist       string
+	Album        string
+	Genre        string
+	Year         string
+	Charter      string
+	Resolution   int
+	Difficulty   int
+	Length       float64
+	Offset       float64
+	PreviewStart float64
+	PreviewEnd   float64
+	// audio file of the song, relative to the chart
+	MusicStream          string
 	TimeSignatureChanges []TSChange
 	TempoChanges         []TempoChange
+	Events               []GlobalEvent
 	Tracks               []InstrumentTrack
 }
 
+func parseGlobalEvent(tick int, text string) GlobalEvent {
+	kind, rest, _ := strings.Cut(text, " ")
+	switch kind {
+	case "section":
+		return GlobalEvent{tick, EventSection, rest}
+	case "lyric":
+		return GlobalEvent{tick, EventLyric, rest}
+	case "phrase_start":
+		return GlobalEvent{tick, EventPhraseStart, ""}
+	case "phrase_end":
+		return GlobalEvent{tick, EventPhraseEnd, ""}
+	}
+	return GlobalEvent{tick, EventText, text}
+}
+
 func Parse(uchart *UnstructuredChart) (*Chart, error) {
 	var chart Chart
 	chart.TimeSignatureChanges = []TSChange{}
 	chart.TempoChanges = []TempoChange{}
+	chart.Events = []GlobalEvent{}
 
 	metadata, exists := uchart.sections["Song"]
 	if !exists {
@@ -198,6 +403,10 @@ func Parse(uchart *UnstructuredChart) (*Chart, error) {
 
 	for i := range metadata.values {
 		kv := metadata.values[i]
+		// empty strings like Genre = "" are left unset
+		if len(kv.value) == 0 {
+			continue
+		}
 		log.Debug(kv.key)
 		log.Debug(kv.value[0])
 		switch kv.key {
@@ -270,13 +479,19 @@ func Parse(uchart *UnstructuredChart) (*Chart, error) {
 			if !ok {
 				return nil, fmt.Errorf("chart PreviewStart is not a decimal")
 			}
-			chart.Offset = t
+			chart.PreviewStart = t
+		case "MusicStream":
+			t, ok := kv.value[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("chart MusicStream is not a string")
+			}
+			chart.MusicStream = t
 		case "PreviewEnd":
 			t, ok := kv.value[0].(float64)
 			if !ok {
 				return nil, fmt.Errorf("chart PreviewEnd is not a decimal")
 			}
-			chart.Offset = t
+			chart.PreviewEnd = t
 		}
 	}
 
@@ -290,6 +505,9 @@ func Parse(uchart *UnstructuredChart) (*Chart, error) {
 		if err != nil {
 			return nil, err
 		}
+		if len(kv.value) < 2 {
+			return nil, fmt.Errorf("chart [SyncTrack] line at tick %v is missing values", tick)
+		}
 		switch kv.value[0] {
 		case "B":
 			// Tempo Change
@@ -321,12 +539,43 @@ func Parse(uchart *UnstructuredChart) (*Chart, error) {
 			chart.TimeSignatureChanges = append(chart.TimeSignatureChanges, TSChange{int(tick), numerator, denominator})
 		}
 	}
+	if len(chart.TempoChanges) == 0 {
+		return nil, fmt.Errorf("chart has no Tempo Change in [SyncTrack] section")
+	}
+	// a chart without a time signature is in 4/4
+	if len(chart.TimeSignatureChanges) == 0 {
+		chart.TimeSignatureChanges = append(chart.TimeSignatureChanges, TSChange{0, 4, 4})
+	}
+
+	// the events section is optional
+	events := uchart.sections["Events"]
+	for i := range events.values {
+		kv := events.values[i]
+		tick, err := strconv.ParseInt(kv.key, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if len(kv.value) == 0 || kv.value[0] != "E" {
+			continue
+		}
+
+		// unquoted events are split into several values, so join them back together
+		words := []string{}
+		for j := 1; j < len(kv.value); j++ {
+			words = append(words, fmt.Sprint(kv.value[j]))
+		}
+		chart.Events = append(chart.Events, parseGlobalEvent(int(tick), strings.Join(words, " ")))
+	}
 
 	for section_name := range uchart.sections {
 		if section_name == "Song" || section_name == "SyncTrack" || section_name == "Events" {
 			continue
 		}
-		track := InstrumentTrack{section_name, []Note{}}
+		difficulty, instrument, ok := ParseTrackName(section_name)
+		if !ok {
+			log.Warn("unknown track", "track", section_name)
+		}
+		track := InstrumentTrack{section_name, difficulty, instrument, []Note{}, []SpecialPhrase{}}
 		log.Info("parsing track", "track", section_name)
 		section := uchart.sections[section_name]
 		for i := range section.values {
@@ -336,27 +585,128 @@ func Parse(uchart *UnstructuredChart) (*Chart, error) {
 				return nil, err
 			}
 
-			switch kv.value[0].(string) {
+			if len(kv.value) == 0 {
+				return nil, fmt.Errorf("chart [%v] line at tick %v has no values", section_name, tick)
+			}
+			switch kv.value[0] {
 			case "N":
 				// note
-				typ := int(kv.value[1].(float64))
-				length := int(kv.value[2].(float64))
+				if len(kv.value) != 3 {
+					return nil, fmt.Errorf("chart [%v] note at tick %v does not have a type and length", section_name, tick)
+				}
+				t, ok := kv.value[1].(float64)
+				typ := int(t)
+				if !ok || float64(typ) != t {
+					return nil, fmt.Errorf("chart [%v] note type at tick %v is not an int", section_name, tick)
+				}
+				t, ok = kv.value[2].(float64)
+				length := int(t)
+				if !ok || float64(length) != t {
+					return nil, fmt.Errorf("chart [%v] note length at tick %v is not an int", section_name, tick)
+				}
 
-				track.Notes = append(track.Notes, Note{int(tick), typ, length})
+				track.Notes = append(track.Notes, Note{Tick: int(tick), Typ: typ, Len: length})
 			case "S":
 				// special phrase
+				if len(kv.value) != 3 {
+					return nil, fmt.Errorf("chart [%v] special phrase at tick %v does not have a type and length", section_name, tick)
+				}
+				t, ok := kv.value[1].(float64)
+				typ := int(t)
+				if !ok || float64(typ) != t {
+					return nil, fmt.Errorf("chart [%v] special phrase type at tick %v is not an int", section_name, tick)
+				}
+				t, ok = kv.value[2].(float64)
+				length := int(t)
+				if !ok || float64(length) != t {
+					return nil, fmt.Errorf("chart [%v] special phrase length at tick %v is not an int", section_name, tick)
+				}
 
+				track.Phrases = append(track.Phrases, SpecialPhrase{int(tick), PhraseKind(typ), length})
 			}
 		}
+		if instrument.hasModifiers() {
+			track.Notes = foldModifiers(track.Notes, chart.Resolution)
+		}
 		chart.Tracks = append(chart.Tracks, track)
 	}
 
+	// sections are read from a map, so put the tracks in a stable order
+	slices.SortFunc(chart.Tracks, func(a, b InstrumentTrack) int {
+		if a.Instrument != b.Instrument {
+			return int(a.Instrument) - int(b.Instrument)
+		}
+		if a.Difficulty != b.Difficulty {
+			return int(a.Difficulty) - int(b.Difficulty)
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+
 	return &chart, nil
 }
 
+// removes the forced and tap modifier notes, turning them into flags on the notes they
+// share a tick with, and works out which notes are HOPOs
+func foldModifiers(notes []Note, resolution int) []Note {
+	// notes closer than this to the previous one are natural HOPOs
+	threshold := 65 * resolution / 192
+
+	out := []Note{}
+	prev := []Note{}
+	for start := 0; start < len(notes); {
+		end := start
+		for end < len(notes) && notes[end].Tick == notes[start].Tick {
+			end++
+		}
+
+		forced := false
+		tap := false
+		chord := []Note{}
+		for _, note := range notes[start:end] {
+			switch note.Typ {
+			case NoteForced:
+				forced = true
+			case NoteTap:
+				tap = true
+			case NoteOpen:
+				note.Open = true
+				chord = append(chord, note)
+			default:
+				chord = append(chord, note)
+			}
+		}
+		start = end
+
+		if len(chord) == 0 {
+			continue
+		}
+
+		// single notes close after a different note are hammered on or pulled off
+		hopo := len(prev) > 0 && len(chord) == 1 && chord[0].Tick-prev[0].Tick <= threshold
+		for _, note := range prev {
+			if hopo && note.Typ == chord[0].Typ {
+				hopo = false
+			}
+		}
+		if forced {
+			hopo = !hopo
+		}
+
+		for i := range chord {
+			chord[i].HOPO = hopo && !tap
+			chord[i].Forced = forced
+			chord[i].Tap = tap
+		}
+		out = append(out, chord...)
+		prev = chord
+	}
+	return out
+}
+
 type ChartCursor struct {
 	Chart Chart
-	// the current tick, events on this tick will *not* be considered the next event
+	// the current tick, events on this tick will *not* be considered the next event, except for
+	// tick 0 before the cursor has been advanced
 	current_tick int
 	track        int
 	// index of the next time signature change in the TimeSignatureChanges array
@@ -365,6 +715,16 @@ type ChartCursor struct {
 	tempo_index int
 	// index of the next note in the Notes array
 	note_index int
+	// index of the next global event in the Events array
+	event_index int
+	// index of the next phrase to start in the Phrases array
+	phrase_index int
+	// the track's phrases ordered by the tick they end on
+	phrase_ends []SpecialPhrase
+	// index of the next phrase to end in phrase_ends
+	phrase_end_index int
+	// whether AdvanceTick has been called, before that the events on tick 0 are still to come
+	advanced bool
 }
 
 func NewChartCursor(chart Chart, track string) (*ChartCursor, error) {
@@ -374,7 +734,13 @@ func NewChartCursor(chart Chart, track string) (*ChartCursor, error) {
 	for i := range chart.Tracks {
 		if chart.Tracks[i].Name == track {
 			cursor.track = i
-			cursor.AdvanceTick(0)
+			cursor.phrase_ends = slices.Clone(chart.Tracks[i].Phrases)
+			slices.SortStableFunc(cursor.phrase_ends, func(a, b SpecialPhrase) int {
+				return (a.Tick + a.Len) - (b.Tick + b.Len)
+			})
+			// the tempo and time signature at tick 0 are in effect from the start, but the notes,
+			// events and phrases on it are left for the first NextEvent
+			cursor.advanceSync()
 			log.Info("initializing cursor", "ts_index", cursor.ts_index, "tempo_index", cursor.tempo_index, "note_index", cursor.note_index)
 			return &cursor, nil
 		}
@@ -383,9 +749,24 @@ func NewChartCursor(chart Chart, track string) (*ChartCursor, error) {
 	return nil, fmt.Errorf("unable to find track")
 }
 
-// advances the cursor by the specified number of ticks
-func (cursor *ChartCursor) AdvanceTick(ticks int) {
-	cursor.current_tick += ticks
+// returns the time in seconds of the end of the last note of any track
+func (chart Chart) LastNoteTime() float64 {
+	last := 0
+	for _, track := range chart.Tracks {
+		for _, note := range track.Notes {
+			last = max(last, note.Tick+note.Len)
+		}
+	}
+	return chart.TickTime(last)
+}
+
+// returns the track the cursor is playing
+func (cursor ChartCursor) Track() InstrumentTrack {
+	return cursor.Chart.Tracks[cursor.track]
+}
+
+// moves the time signature and tempo indices past the changes up to the current tick
+func (cursor *ChartCursor) advanceSync() {
 	i := 0
 
 	// advance ts_index
@@ -397,11 +778,36 @@ func (cursor *ChartCursor) AdvanceTick(ticks int) {
 	for i = cursor.tempo_index; i < len(cursor.Chart.TempoChanges) && cursor.Chart.TempoChanges[i].tick <= cursor.current_tick; i++ {
 	}
 	cursor.tempo_index = i
+}
+
+// advances the cursor by the specified number of ticks. Advancing by zero ticks consumes the
+// events on tick 0 after the first NextEvent.
+func (cursor *ChartCursor) AdvanceTick(ticks int) {
+	cursor.current_tick += ticks
+	cursor.advanced = true
+	cursor.advanceSync()
+	i := 0
 
 	// advance note_index
 	for i = cursor.note_index; i < len(cursor.Chart.Tracks[cursor.track].Notes) && cursor.Chart.Tracks[cursor.track].Notes[i].Tick <= cursor.current_tick; i++ {
 	}
 	cursor.note_index = i
+
+	// advance event_index
+	for i = cursor.event_index; i < len(cursor.Chart.Events) && cursor.Chart.Events[i].Tick <= cursor.current_tick; i++ {
+	}
+	cursor.event_index = i
+
+	// advance phrase_index
+	phrases := cursor.Chart.Tracks[cursor.track].Phrases
+	for i = cursor.phrase_index; i < len(phrases) && phrases[i].Tick <= cursor.current_tick; i++ {
+	}
+	cursor.phrase_index = i
+
+	// advance phrase_end_index
+	for i = cursor.phrase_end_index; i < len(cursor.phrase_ends) && cursor.phrase_ends[i].Tick+cursor.phrase_ends[i].Len <= cursor.current_tick; i++ {
+	}
+	cursor.phrase_end_index = i
 }
 
 func (cursor ChartCursor) NextNote() ([]Note, int) {
@@ -429,15 +835,57 @@ func (cursor ChartCursor) NextTimestampChange() (*TSChange, int) {
 	return &cursor.Chart.TimeSignatureChanges[cursor.ts_index], cursor.Chart.TimeSignatureChanges[cursor.ts_index].tick - cursor.current_tick
 }
 
+// returns all the global events on the next tick which has any
+func (cursor ChartCursor) NextGlobalEvent() ([]GlobalEvent, int) {
+	if cursor.event_index >= len(cursor.Chart.Events) {
+		return []GlobalEvent{}, math.MaxInt
+	}
+	next_tick := cursor.Chart.Events[cursor.event_index].Tick
+	i := cursor.event_index
+	for i = cursor.event_index; i < len(cursor.Chart.Events) && cursor.Chart.Events[i].Tick == next_tick; i++ {
+	}
+	return cursor.Chart.Events[cursor.event_index:i], (next_tick - cursor.current_tick)
+}
+
+// returns all the special phrases starting on the next tick which has any
+func (cursor ChartCursor) NextPhraseStart() (PhraseStart, int) {
+	phrases := cursor.Chart.Tracks[cursor.track].Phrases
+	if cursor.phrase_index >= len(phrases) {
+		return PhraseStart{}, math.MaxInt
+	}
+	next_tick := phrases[cursor.phrase_index].Tick
+	i := cursor.phrase_index
+	for i = cursor.phrase_index; i < len(phrases) && phrases[i].Tick == next_tick; i++ {
+	}
+	return PhraseStart(phrases[cursor.phrase_index:i]), (next_tick - cursor.current_tick)
+}
+
+// returns all the special phrases ending on the next tick which has any
+func (cursor ChartCursor) NextPhraseEnd() (PhraseEnd, int) {
+	if cursor.phrase_end_index >= len(cursor.phrase_ends) {
+		return PhraseEnd{}, math.MaxInt
+	}
+	first := cursor.phrase_ends[cursor.phrase_end_index]
+	next_tick := first.Tick + first.Len
+	i := cursor.phrase_end_index
+	for i = cursor.phrase_end_index; i < len(cursor.phrase_ends) && cursor.phrase_ends[i].Tick+cursor.phrase_ends[i].Len == next_tick; i++ {
+	}
+	return PhraseEnd(cursor.phrase_ends[cursor.phrase_end_index:i]), (next_tick - cursor.current_tick)
+}
+
 func (cursor ChartCursor) NextEvent() ([]any, int) {
 	notes, note_adv := cursor.NextNote()
 	tempo, tempo_adv := cursor.NextTempoChange()
 	ts, ts_adv := cursor.NextTimestampChange()
+	events, event_adv := cursor.NextGlobalEvent()
+	phrase_starts, phrase_start_adv := cursor.NextPhraseStart()
+	phrase_ends, phrase_end_adv := cursor.NextPhraseEnd()
 
-	min_adv := min(note_adv, tempo_adv, ts_adv)
+	min_adv := min(note_adv, tempo_adv, ts_adv, event_adv, phrase_start_adv, phrase_end_adv)
 
-	if min_adv == 0 {
-		log.Error("adv of zero before end", "note_cursor", cursor.note_index, "temp_index", cursor.tempo_index, "ts", cursor.ts_index, "note_adv", note_adv, "tempo_adv", tempo_adv, "ts_adv", ts_adv)
+	// only the events on tick 0 are reported before the cursor has moved
+	if min_adv == 0 && cursor.advanced {
+		log.Error("adv of zero before end", "note_cursor", cursor.note_index, "temp_index", cursor.tempo_index, "ts", cursor.ts_index, "event_index", cursor.event_index, "note_adv", note_adv, "tempo_adv", tempo_adv, "ts_adv", ts_adv, "event_adv", event_adv)
 		panic("")
 	}
 
@@ -446,6 +894,14 @@ func (cursor ChartCursor) NextEvent() ([]any, int) {
 	}
 
 	out := []any{}
+	// phrases come first so the notes on the same tick already know which phrase they are in,
+	// and ends before starts so back to back phrases are handled in order
+	if phrase_end_adv == min_adv {
+		out = append(out, phrase_ends)
+	}
+	if phrase_start_adv == min_adv {
+		out = append(out, phrase_starts)
+	}
 	if note_adv == min_adv {
 		out = append(out, notes)
 	}
@@ -455,14 +911,81 @@ func (cursor ChartCursor) NextEvent() ([]any, int) {
 	if ts_adv == min_adv {
 		out = append(out, ts)
 	}
+	if event_adv == min_adv {
+		out = append(out, events)
+	}
 
 	return out, min_adv
 }
 
+// returns the lyrics of the phrase which starts at the given tick, up to its phrase_end
+func (chart Chart) PhraseLyrics(tick int) []GlobalEvent {
+	lyrics := []GlobalEvent{}
+	started := false
+	for i := range chart.Events {
+		event := chart.Events[i]
+		if event.Tick < tick {
+			continue
+		}
+		switch event.Kind {
+		case EventPhraseStart:
+			if started {
+				return lyrics
+			}
+			started = true
+		case EventPhraseEnd:
+			if started {
+				return lyrics
+			}
+		case EventLyric:
+			if started {
+				lyrics = append(lyrics, event)
+			}
+		}
+	}
+	return lyrics
+}
+
 func TicksPerSecond(resolution float64, bpm float64, denominator float64) float64 {
 	return resolution * (bpm / 60) * (4 / denominator)
 }
 
+// returns the time in seconds from the start of the chart at which the tick is played
+func (chart Chart) TickTime(tick int) float64 {
+	seconds := 0.0
+	prev := 0
+	tempo_index := 0
+	ts_index := 0
+	for prev < tick {
+		for tempo_index+1 < len(chart.TempoChanges) && chart.TempoChanges[tempo_index+1].tick <= prev {
+			tempo_index++
+		}
+		for ts_index+1 < len(chart.TimeSignatureChanges) && chart.TimeSignatureChanges[ts_index+1].tick <= prev {
+			ts_index++
+		}
+
+		// the tempo stays the same until the next change of either kind
+		next := tick
+		if tempo_index+1 < len(chart.TempoChanges) {
+			next = min(next, chart.TempoChanges[tempo_index+1].tick)
+		}
+		if ts_index+1 < len(chart.TimeSignatureChanges) {
+			next = min(next, chart.TimeSignatureChanges[ts_index+1].tick)
+		}
+
+		bpm := chart.TempoChanges[tempo_index].tempo
+		denominator := chart.TimeSignatureChanges[ts_index].denominator
+		seconds += float64(next-prev) / TicksPerSecond(float64(chart.Resolution), bpm, float64(denominator))
+		prev = next
+	}
+	return seconds
+}
+
+// returns the time in seconds at which the cursor's current tick is played
+func (cursor ChartCursor) CurrentTime() float64 {
+	return cursor.Chart.TickTime(cursor.current_tick)
+}
+
 func (cursor ChartCursor) CurrentTicksPerSecond() float64 {
 	bpm := cursor.Chart.TempoChanges[max(0, cursor.tempo_index-1)].tempo
 	denominator := cursor.Chart.TimeSignatureChanges[max(0, cursor.ts_index-1)].denominator
@@ -473,11 +996,15 @@ func (cursor ChartCursor) CurrentTicksPerSecond() float64 {
 func OpenChart(filename string) (*Chart, error) {
 	file, err := os.Open(filename)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
+	defer file.Close()
 	// skip BOM
-	file.Seek(3, 0)
-	uchart, err := ParseRaw(file)
+	reader := bufio.NewReader(file)
+	if bom, err := reader.Peek(3); err == nil && string(bom) == "\xef\xbb\xbf" {
+		reader.Discard(3)
+	}
+	uchart, err := ParseRaw(reader)
 	if err != nil {
 		return nil, err
 	}
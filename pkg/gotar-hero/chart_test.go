@@ -0,0 +1,341 @@
+package gotar_hero
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFoldModifiers(t *testing.T) {
+	note := func(tick, typ int) Note {
+		return Note{Tick: tick, Typ: typ}
+	}
+	hopo := func(n Note) Note {
+		n.HOPO = true
+		return n
+	}
+
+	tests := []struct {
+		name       string
+		resolution int
+		notes      []Note
+		want       []Note
+	}{
+		{
+			name:       "note within the threshold is a HOPO",
+			resolution: 192,
+			notes:      []Note{note(0, 0), note(65, 1)},
+			want:       []Note{note(0, 0), hopo(note(65, 1))},
+		},
+		{
+			name:       "note past the threshold is strummed",
+			resolution: 192,
+			notes:      []Note{note(0, 0), note(66, 1)},
+			want:       []Note{note(0, 0), note(66, 1)},
+		},
+		{
+			name:       "threshold scales with the resolution",
+			resolution: 480,
+			notes:      []Note{note(0, 0), note(162, 1), note(325, 2)},
+			want:       []Note{note(0, 0), hopo(note(162, 1)), note(325, 2)},
+		},
+		{
+			name:       "same fret again is strummed",
+			resolution: 192,
+			notes:      []Note{note(0, 2), note(30, 2)},
+			want:       []Note{note(0, 2), note(30, 2)},
+		},
+		{
+			name:       "fret held in the chord before is strummed",
+			resolution: 192,
+			notes:      []Note{note(0, 0), note(0, 1), note(30, 1)},
+			want:       []Note{note(0, 0), note(0, 1), note(30, 1)},
+		},
+		{
+			name:       "chords are strummed",
+			resolution: 192,
+			notes:      []Note{note(0, 0), note(30, 1), note(30, 2)},
+			want:       []Note{note(0, 0), note(30, 1), note(30, 2)},
+		},
+		{
+			name:       "forced flips a HOPO to strummed",
+			resolution: 192,
+			notes:      []Note{note(0, 0), note(30, 1), note(30, NoteForced)},
+			want:       []Note{note(0, 0), {Tick: 30, Typ: 1, Forced: true}},
+		},
+		{
+			name:       "forced flips a strummed note to a HOPO",
+			resolution: 192,
+			notes:      []Note{note(0, 0), note(200, 1), note(200, NoteForced)},
+			want:       []Note{note(0, 0), {Tick: 200, Typ: 1, HOPO: true, Forced: true}},
+		},
+		{
+			name:       "tap applies to every note on its tick",
+			resolution: 192,
+			notes:      []Note{note(0, 0), note(30, 1), note(30, 3), note(30, NoteTap)},
+			want:       []Note{note(0, 0), {Tick: 30, Typ: 1, Tap: true}, {Tick: 30, Typ: 3, Tap: true}},
+		},
+		{
+			name:       "tap wins over HOPO",
+			resolution: 192,
+			notes:      []Note{note(0, 0), note(30, 1), note(30, NoteTap)},
+			want:       []Note{note(0, 0), {Tick: 30, Typ: 1, Tap: true}},
+		},
+		{
+			name:       "open note is kept and marked",
+			resolution: 192,
+			notes:      []Note{note(0, NoteOpen), note(200, 0)},
+			want:       []Note{{Tick: 0, Typ: NoteOpen, Open: true}, note(200, 0)},
+		},
+		{
+			name:       "modifier without a note is dropped",
+			resolution: 192,
+			notes:      []Note{note(0, NoteForced), note(0, NoteTap), note(100, 0)},
+			want:       []Note{note(100, 0)},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := foldModifiers(test.notes, test.resolution)
+			if !slices.Equal(got, test.want) {
+				t.Errorf("foldModifiers() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseTrackName(t *testing.T) {
+	tests := []struct {
+		name       string
+		difficulty Difficulty
+		instrument Instrument
+		ok         bool
+	}{
+		{"ExpertSingle", DifficultyExpert, InstrumentSingle, true},
+		{"EasyDoubleBass", DifficultyEasy, InstrumentDoubleBass, true},
+		{"MediumDrums", DifficultyMedium, InstrumentDrums, true},
+		{"HardGHLGuitar", DifficultyHard, InstrumentGHLGuitar, true},
+		{"ExpertKeyboard", DifficultyExpert, InstrumentKeyboard, true},
+		{"ExpertVocals", DifficultyEasy, InstrumentUnknown, false},
+		{"Single", DifficultyEasy, InstrumentUnknown, false},
+		{"expertsingle", DifficultyEasy, InstrumentUnknown, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			difficulty, instrument, ok := ParseTrackName(test.name)
+			if difficulty != test.difficulty || instrument != test.instrument || ok != test.ok {
+				t.Errorf("ParseTrackName(%q) = %v, %v, %v, want %v, %v, %v",
+					test.name, difficulty, instrument, ok, test.difficulty, test.instrument, test.ok)
+			}
+		})
+	}
+}
+
+// parses a chart with a minimal [Song] and [SyncTrack] and the given ExpertSingle lines
+func parseTrack(lines string) (*Chart, error) {
+	uchart, err := ParseRaw(strings.NewReader("[Song]\n{\n  Resolution = 192\n}\n[SyncTrack]\n{\n  0 = B 120000\n}\n[ExpertSingle]\n{\n" + lines + "}\n"))
+	if err != nil {
+		return nil, err
+	}
+	return Parse(uchart)
+}
+
+func TestParseMalformedTrack(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines string
+		ok    bool
+	}{
+		{"note", "  0 = N 0 0\n", true},
+		{"note without length", "  0 = N 2\n", false},
+		{"note without type", "  0 = N\n", false},
+		{"note with a string type", "  0 = N green 0\n", false},
+		{"note with a fractional length", "  0 = N 0 1.5\n", false},
+		{"line without values", "  0 = \n", false},
+		{"special phrase", "  0 = S 2 192\n", true},
+		{"special phrase without length", "  0 = S 2\n", false},
+		{"special phrase with a string length", "  0 = S 2 long\n", false},
+		{"unknown line is ignored", "  0 = E solo\n", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseTrack(test.lines)
+			if (err == nil) != test.ok {
+				t.Errorf("Parse(%q) error = %v, want ok %v", test.lines, err, test.ok)
+			}
+		})
+	}
+}
+
+func TestParseGlobalEvent(t *testing.T) {
+	tests := []struct {
+		text string
+		want GlobalEvent
+	}{
+		{"section Intro", GlobalEvent{10, EventSection, "Intro"}},
+		{"section Guitar Solo 1", GlobalEvent{10, EventSection, "Guitar Solo 1"}},
+		{"lyric Hel-", GlobalEvent{10, EventLyric, "Hel-"}},
+		{"lyric lo", GlobalEvent{10, EventLyric, "lo"}},
+		{"phrase_start", GlobalEvent{10, EventPhraseStart, ""}},
+		{"phrase_end", GlobalEvent{10, EventPhraseEnd, ""}},
+		{"crowd_clap", GlobalEvent{10, EventText, "crowd_clap"}},
+		{"end", GlobalEvent{10, EventText, "end"}},
+	}
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			got := parseGlobalEvent(10, test.text)
+			if got != test.want {
+				t.Errorf("parseGlobalEvent(%q) = %+v, want %+v", test.text, got, test.want)
+			}
+		})
+	}
+}
+
+// parses a chart with a minimal [Song] and [SyncTrack], the given [Events] lines and an
+// ExpertSingle track with a note on tick 0
+func parseEvents(lines string) (*Chart, error) {
+	uchart, err := ParseRaw(strings.NewReader("[Song]\n{\n  Resolution = 192\n}\n[SyncTrack]\n{\n  0 = B 120000\n}\n[Events]\n{\n" + lines + "}\n[ExpertSingle]\n{\n  0 = N 0 0\n}\n"))
+	if err != nil {
+		return nil, err
+	}
+	return Parse(uchart)
+}
+
+func TestParseEvents(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines string
+		want  []GlobalEvent
+	}{
+		{"quoted", "  0 = E \"section Intro\"\n", []GlobalEvent{{0, EventSection, "Intro"}}},
+		{"unquoted is joined", "  0 = E section Verse 1\n", []GlobalEvent{{0, EventSection, "Verse 1"}}},
+		{"unquoted lyric keeps its joiner", "  96 = E lyric Hel-\n", []GlobalEvent{{96, EventLyric, "Hel-"}}},
+		{"line without values is skipped", "  0 = \n  96 = E phrase_start\n", []GlobalEvent{{96, EventPhraseStart, ""}}},
+		{"other keys are skipped", "  0 = X section Intro\n", []GlobalEvent{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chart, err := parseEvents(test.lines)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if !slices.Equal(chart.Events, test.want) {
+				t.Errorf("Events = %+v, want %+v", chart.Events, test.want)
+			}
+		})
+	}
+}
+
+func TestPhraseLyrics(t *testing.T) {
+	chart := Chart{Events: []GlobalEvent{
+		{0, EventSection, "Intro"},
+		{100, EventPhraseStart, ""},
+		{100, EventLyric, "Hel-"},
+		{120, EventLyric, "lo"},
+		{140, EventPhraseEnd, ""},
+		{150, EventLyric, "stray"},
+		{200, EventPhraseStart, ""},
+		{210, EventLyric, "world"},
+		{300, EventPhraseStart, ""},
+		{310, EventLyric, "again"},
+	}}
+	tests := []struct {
+		name string
+		tick int
+		want []GlobalEvent
+	}{
+		{"ends on phrase_end", 100, []GlobalEvent{{100, EventLyric, "Hel-"}, {120, EventLyric, "lo"}}},
+		{"ends on the next phrase_start", 200, []GlobalEvent{{210, EventLyric, "world"}}},
+		{"last phrase runs to the end", 300, []GlobalEvent{{310, EventLyric, "again"}}},
+		{"no phrase", 400, []GlobalEvent{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := chart.PhraseLyrics(test.tick)
+			if !slices.Equal(got, test.want) {
+				t.Errorf("PhraseLyrics(%v) = %+v, want %+v", test.tick, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCursorTickZero(t *testing.T) {
+	uchart, err := ParseRaw(strings.NewReader("[Song]\n{\n  Resolution = 192\n}\n[SyncTrack]\n{\n  0 = B 120000\n}\n" +
+		"[Events]\n{\n  0 = E \"section Intro\"\n  192 = E \"section Verse\"\n}\n" +
+		"[ExpertSingle]\n{\n  0 = N 0 0\n  0 = S 2 384\n  192 = N 1 0\n}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chart, err := Parse(uchart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursor, err := NewChartCursor(*chart, "ExpertSingle")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, adv := cursor.NextGlobalEvent()
+	if adv != 0 || !slices.Equal(events, []GlobalEvent{{0, EventSection, "Intro"}}) {
+		t.Errorf("NextGlobalEvent() = %+v, %v, want the Intro section on tick 0", events, adv)
+	}
+	phrases, adv := cursor.NextPhraseStart()
+	if adv != 0 || !slices.Equal(phrases, PhraseStart{{0, PhraseStarPower, 384}}) {
+		t.Errorf("NextPhraseStart() = %+v, %v, want the star power phrase on tick 0", phrases, adv)
+	}
+
+	// walk the whole chart, collecting what every NextEvent reports
+	sections := []string{}
+	notes := 0
+	starts := 0
+	ends := 0
+	for {
+		out, adv := cursor.NextEvent()
+		if len(out) == 0 {
+			break
+		}
+		for _, item := range out {
+			switch u := item.(type) {
+			case []GlobalEvent:
+				for _, event := range u {
+					sections = append(sections, event.Text)
+				}
+			case []Note:
+				notes += len(u)
+			case PhraseStart:
+				starts += len(u)
+			case PhraseEnd:
+				ends += len(u)
+			}
+		}
+		cursor.AdvanceTick(adv)
+	}
+	if !slices.Equal(sections, []string{"Intro", "Verse"}) {
+		t.Errorf("sections = %v, want [Intro Verse]", sections)
+	}
+	if notes != 2 || starts != 1 || ends != 1 {
+		t.Errorf("got %v notes, %v phrase starts and %v phrase ends, want 2, 1 and 1", notes, starts, ends)
+	}
+}
+
+func TestPlayableNotes(t *testing.T) {
+	tests := []struct {
+		name       string
+		instrument Instrument
+		notes      []Note
+		want       int
+	}{
+		{"frets", InstrumentSingle, []Note{{Typ: 0}, {Typ: 4}}, 2},
+		{"open notes count", InstrumentSingle, []Note{{Typ: NoteOpen, Open: true}, {Typ: 1}}, 2},
+		{"notes without a lane don't", InstrumentSingle, []Note{{Typ: 9}, {Typ: 1}}, 1},
+		{"kicks don't", InstrumentDrums, []Note{{Typ: 0}, {Typ: 1}, {Typ: 5}}, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			track := InstrumentTrack{Instrument: test.instrument, Notes: test.notes}
+			if got := track.PlayableNotes(); got != test.want {
+				t.Errorf("PlayableNotes() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
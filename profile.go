@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	tea "github.com/charmbracelet/bubbletea/v2"
+	"github.com/charmbracelet/lipgloss/v2"
+	"github.com/charmbracelet/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+// file the player profiles are kept in
+var ProfilesPath = "profiles.db"
+
+const maxNameLength = 24
+
+var profilesBucket = []byte("profiles")
+
+type Settings struct {
+	// half-characters per second the notes move at, 0 uses the server default
+	NoteSpeed int `json:"note_speed"`
+	// the track picked last time, which the track select starts on
+	Track string `json:"track"`
+	// name of the program the menu shows the audio command for
+	AudioPlayer string `json:"audio_player"`
+	// format the audio command asks the server for
+	AudioEncoding string `json:"audio_encoding"`
+	// milliseconds the player's taps land after the audio they hear, which judging allows for
+	AudioOffset int `json:"audio_offset"`
+	// milliseconds the player's taps land after what they see, which note drawing allows for
+	VideoOffset int `json:"video_offset"`
+}
+
+type Profile struct {
+	// SHA256 fingerprint of the player's SSH public key, which the profile is stored under
+	Fingerprint string    `json:"fingerprint"`
+	Name        string    `json:"name"`
+	Settings    Settings  `json:"settings"`
+	PlayCount   int       `json:"play_count"`
+	TotalScore  int       `json:"total_score"`
+	Favorites   []string  `json:"favorites"`
+	Created     time.Time `json:"created"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+func (p *Profile) noteSpeed() int {
+	if p.Settings.NoteSpeed == 0 {
+		return NoteSpeed
+	}
+	return p.Settings.NoteSpeed
+}
+
+func (p *Profile) IsFavorite(song string) bool {
+	return slices.Contains(p.Favorites, song)
+}
+
+func (p *Profile) ToggleFavorite(song string) {
+	if i := slices.Index(p.Favorites, song); i >= 0 {
+		p.Favorites = slices.Delete(p.Favorites, i, i+1)
+	} else {
+		p.Favorites = append(p.Favorites, song)
+	}
+}
+
+// stores profiles in a bolt database keyed on the public key fingerprint
+type Profiles struct {
+	db *bolt.DB
+}
+
+func OpenProfiles(path string) (*Profiles, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open profiles: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(profilesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Profiles{db}, nil
+}
+
+func (p *Profiles) Close() error {
+	return p.db.Close()
+}
+
+// players without a public key get a profile which is never stored
+func (p *Profiles) Save(profile Profile) error {
+	if profile.Fingerprint == "" {
+		return nil
+	}
+	value, err := json.Marshal(profile)
+	if err != nil {
+		return err
+	}
+	return p.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(profilesBucket).Put([]byte(profile.Fingerprint), value)
+	})
+}
+
+// returns the profile for the fingerprint, creating one named after the SSH user on the first connection
+func (p *Profiles) LoadOrCreate(fingerprint string, user string) (*Profile, error) {
+	profile := &Profile{Fingerprint: fingerprint, Name: user, Favorites: []string{}, Created: time.Now()}
+	found := false
+	err := p.db.View(func(tx *bolt.Tx) error {
+		if fingerprint == "" {
+			return nil
+		}
+		value := tx.Bucket(profilesBucket).Get([]byte(fingerprint))
+		if value == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(value, profile)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		log.Info("created profile", "fingerprint", fingerprint, "name", user)
+	}
+	profile.LastSeen = time.Now()
+	return profile, p.Save(*profile)
+}
+
+// trims a new display name and checks it can be shown
+func validateName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("name can't be empty")
+	}
+	if len([]rune(name)) > maxNameLength {
+		return "", fmt.Errorf("name can be at most %d characters", maxNameLength)
+	}
+	for _, r := range name {
+		if !unicode.IsPrint(r) {
+			return "", fmt.Errorf("name can only contain printable characters")
+		}
+	}
+	return name, nil
+}
+
+const (
+	PROFILE_NAME = iota
+	PROFILE_NOTE_SPEED
+	PROFILE_MAX = iota - 1
+)
+
+// note speeds the profile screen steps through
+const (
+	minNoteSpeed  = 100
+	maxNoteSpeed  = 400
+	noteSpeedStep = 25
+)
+
+type ProfileScreen struct {
+	width    int
+	height   int
+	menu     Menu
+	selected int
+	// the name being typed while renaming
+	renaming bool
+	name     string
+	err      error
+}
+
+func NewProfileScreen(menu Menu) ProfileScreen {
+	return ProfileScreen{width: menu.width, height: menu.height, menu: menu}
+}
+
+func (m ProfileScreen) Init() tea.Cmd {
+	return nil
+}
+
+func (m ProfileScreen) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if cmd, ok := m.menu.updateShared(msg, &m.width, &m.height); ok {
+		return m, cmd
+	}
+	switch msg := msg.(type) {
+	case tea.KeyPressMsg:
+		if m.renaming {
+			switch msg.String() {
+			case "esc":
+				m.renaming = false
+				m.err = nil
+			case "enter":
+				name, err := validateName(m.name)
+				m.err = err
+				if err == nil {
+					log.Info("renamed profile", "fingerprint", m.menu.profile.Fingerprint, "from", m.menu.profile.Name, "to", name)
+					m.menu.profile.Name = name
+					m.menu.saveProfile()
+					m.renaming = false
+				}
+			case "backspace":
+				if m.name != "" {
+					runes := []rune(m.name)
+					m.name = string(runes[:len(runes)-1])
+				}
+			default:
+				if msg.Key().Text != "" {
+					m.name += msg.Key().Text
+				}
+			}
+			return m, nil
+		}
+
+		switch msg.String() {
+		case "down", "j":
+			m.selected = min(m.selected+1, PROFILE_MAX)
+		case "up", "k":
+			m.selected = max(m.selected-1, 0)
+		case "left", "h", "right", "l":
+			if m.selected == PROFILE_NOTE_SPEED {
+				step := noteSpeedStep
+				if msg.String() == "left" || msg.String() == "h" {
+					step = -step
+				}
+				m.menu.profile.Settings.NoteSpeed = min(max(m.menu.profile.noteSpeed()+step, minNoteSpeed), maxNoteSpeed)
+				m.menu.saveProfile()
+			}
+		case "space", "enter":
+			if m.selected == PROFILE_NAME {
+				m.renaming = true
+				m.name = m.menu.profile.Name
+			}
+		case "q", "esc":
+			return m.menu.resume()
+		}
+	}
+	return m, nil
+}
+
+func (m ProfileScreen) View() tea.View {
+	profile := m.menu.profile
+	label := func(text string) string {
+		return lipgloss.NewStyle().Foreground(subtle).Width(14).Render(text)
+	}
+	value := func(i int, text string) string {
+		style := lipgloss.NewStyle().Foreground(normal)
+		if i == m.selected {
+			style = style.Foreground(highlight).Bold(true)
+		}
+		return style.Render(text)
+	}
+
+	name := profile.Name
+	if m.renaming {
+		name = m.name + "█"
+	}
+	fingerprint := profile.Fingerprint
+	if fingerprint == "" {
+		fingerprint = "none, this profile is not saved"
+	}
+
+	favorites := []string{}
+	for _, id := range profile.Favorites {
+		if song, ok := m.menu.library.Song(id); ok {
+			favorites = append(favorites, "★ "+song.Title)
+		}
+	}
+	if len(favorites) == 0 {
+		favorites = append(favorites, "press f in the song list to add favorites")
+	}
+
+	details := lipgloss.JoinVertical(0,
+		label("Name")+value(PROFILE_NAME, name),
+		label("Note speed")+value(PROFILE_NOTE_SPEED, fmt.Sprintf("◂ %d ▸", profile.noteSpeed())),
+		label("Audio offset")+fmt.Sprintf("%d ms", profile.Settings.AudioOffset),
+		label("Video offset")+fmt.Sprintf("%d ms", profile.Settings.VideoOffset),
+		"",
+		label("Key")+lipgloss.NewStyle().Foreground(subtle).Render(fingerprint),
+		label("Songs played")+fmt.Sprint(profile.PlayCount),
+		label("Total score")+fmt.Sprint(profile.TotalScore),
+		label("Playing since")+profile.Created.Format("2006-01-02"),
+		"",
+		label("Favorites")+lipgloss.JoinVertical(0, favorites...),
+	)
+	if m.err != nil {
+		details = lipgloss.JoinVertical(0, details, "", lipgloss.NewStyle().Foreground(lipgloss.Color("#b72528")).Render(m.err.Error()))
+	}
+
+	help := "enter rename • ←/→ change • esc back"
+	if m.renaming {
+		help = "enter save • esc cancel"
+	}
+	result := lipgloss.JoinVertical(0,
+		lipgloss.NewStyle().Foreground(highlight).Bold(true).Render("Profile"),
+		"",
+		lipgloss.NewStyle().Foreground(normal).Border(lipgloss.NormalBorder()).BorderForeground(subtle).Padding(1, 2).Width(70).Render(details),
+		lipgloss.NewStyle().Foreground(subtle).Render(help),
+	)
+	result = lipgloss.Place(m.width, m.height, 0.5, 0.5, result)
+	view := tea.NewView(result)
+	view.KeyReleases = true
+	return view
+}
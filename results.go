@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea/v2"
+	"github.com/charmbracelet/lipgloss/v2"
+	"github.com/charmbracelet/log"
+)
+
+type Results struct {
+	width    int
+	height   int
+	selected int
+	menu     Menu
+	song     Song
+	track    string
+	result   Result
+	// the rest of the song, which plays out over the results
+	audio *PlaybackGroup
+}
+
+const (
+	RESULTS_RETRY = iota
+	RESULTS_MENU
+	RESULTS_MAX = iota - 1
+)
+
+// score compared to hitting every note perfectly without a multiplier needed for each star
+var starThresholds = []float64{0.1, 0.5, 1.0, 2.0, 2.8}
+
+func (r Result) Stars() int {
+	if r.Notes == 0 {
+		return 0
+	}
+	base := float64(r.Notes) * Judgements[0].Score
+	stars := 0
+	for _, threshold := range starThresholds {
+		if float64(r.Score) >= threshold*base {
+			stars++
+		}
+	}
+	return stars
+}
+
+func (m Results) Init() tea.Cmd {
+	return nil
+}
+
+func (m Results) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if cmd, ok := m.menu.updateShared(msg, &m.width, &m.height); ok {
+		return m, cmd
+	}
+	switch msg := msg.(type) {
+	case tea.KeyPressMsg:
+		switch msg.String() {
+		case "down", "j":
+			m.selected = min(m.selected+1, RESULTS_MAX)
+		case "up", "k":
+			m.selected = max(m.selected-1, 0)
+		case "r":
+			return m.retry()
+		case "q", "esc":
+			return m.quit()
+		case "space", "enter":
+			switch m.selected {
+			case RESULTS_RETRY:
+				return m.retry()
+			case RESULTS_MENU:
+				return m.quit()
+			}
+		}
+	}
+	return m, nil
+}
+
+func (m Results) retry() (tea.Model, tea.Cmd) {
+	m.audio.Stop()
+	game, err := NewGame(m.menu, m.song, m.track)
+	if err != nil {
+		log.Error("failed to restart game", "error", err)
+		return m.menu.resume()
+	}
+	return game, game.Init()
+}
+
+func (m Results) quit() (tea.Model, tea.Cmd) {
+	m.audio.Stop()
+	return m.menu.resume()
+}
+
+func (m Results) difficulty() string {
+	for _, track := range m.song.Chart.Tracks {
+		if track.Name == m.track {
+			return track.DisplayName()
+		}
+	}
+	return m.track
+}
+
+func (m Results) View() tea.View {
+	title := lipgloss.NewStyle().Foreground(highlight).Bold(true).Render(m.song.Title)
+	if m.song.Artist != "" {
+		title += lipgloss.NewStyle().Foreground(subtle).Render(" by " + m.song.Artist)
+	}
+
+	stars := m.result.Stars()
+	starLine := lipgloss.NewStyle().Foreground(highlight).Render(strings.Repeat("★", stars)) +
+		lipgloss.NewStyle().Foreground(subtle).Render(strings.Repeat("☆", len(starThresholds)-stars))
+
+	stats := lipgloss.JoinVertical(0,
+		fmt.Sprintf("Difficulty: %s", m.difficulty()),
+		fmt.Sprintf("Score:      %d", m.result.Score),
+		fmt.Sprintf("Accuracy:   %.1f%% (%d/%d)", m.result.HitPercentage(), m.result.NotesHit, m.result.Notes),
+		fmt.Sprintf("Max combo:  %d", m.result.MaxCombo),
+	)
+
+	laneColors := []string{"#19a11b", "#b72528", "#cab50c", "#138ed2", "#a05206"}
+	laneNames := []string{"Green", "Red", "Yellow", "Blue", "Orange"}
+	lanes := ""
+	for i := range 5 {
+		name := lipgloss.NewStyle().Foreground(lipgloss.Color(laneColors[i])).Width(8).Render(laneNames[i])
+		lanes = lipgloss.JoinVertical(0, lanes, fmt.Sprintf("%s %4d hit %4d missed", name, m.result.LaneHits[i], m.result.LaneMisses[i]))
+	}
+	if m.result.OpenHits+m.result.OpenMisses > 0 {
+		name := lipgloss.NewStyle().Foreground(lipgloss.Color("#a855f7")).Width(8).Render("Open")
+		lanes = lipgloss.JoinVertical(0, lanes, fmt.Sprintf("%s %4d hit %4d missed", name, m.result.OpenHits, m.result.OpenMisses))
+	}
+
+	buttons := ""
+	for i := range RESULTS_MAX + 1 {
+		var button string
+		switch i {
+		case RESULTS_RETRY:
+			button = "Retry"
+		case RESULTS_MENU:
+			button = "Menu"
+		}
+		color := subtle
+		if m.selected == i {
+			color = highlight
+		}
+		buttons = lipgloss.JoinVertical(0.0, buttons, lipgloss.NewStyle().Foreground(color).Bold(true).Border(lipgloss.NormalBorder()).BorderForeground(color).Padding(0, 2).Width(30).Render(button))
+	}
+
+	result := lipgloss.JoinVertical(0.5,
+		title,
+		"\n",
+		starLine,
+		"\n",
+		lipgloss.NewStyle().Foreground(normal).Border(lipgloss.NormalBorder()).Padding(1, 2).Render(lipgloss.JoinHorizontal(0, stats, "      ", lanes)),
+		"\n",
+		buttons,
+	)
+	result = lipgloss.Place(m.width, m.height, 0.5, 0.5, result)
+	view := tea.NewView(result)
+	view.KeyReleases = true
+	return view
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+var (
+	// how long a game waits for an audio connection before its pairing code expires
+	PairTimeout = 5 * time.Minute
+	// how long a session outlives its game and audio connections, so a player who reconnects gets
+	// it back
+	SessionGrace = time.Minute
+)
+
+// letters and digits which can't be mistaken for each other
+const pairCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+const pairCodeLength = 6
+
+const (
+	// wrong codes one client can try within pairAttemptWindow, so codes can't be guessed. Clients
+	// are told apart by their address and key, so players sharing an address behind NAT don't
+	// lock each other out.
+	maxFailedPairs    = 5
+	pairAttemptWindow = time.Minute
+)
+
+var (
+	errUnknownCode   = errors.New("no game is waiting for this code, check the code shown in the game")
+	errAlreadyPaired = errors.New("this game already has an audio connection")
+	errTooManyCodes  = errors.New("too many wrong codes, wait a minute before trying again")
+)
+
+// state shared by a player's game connection and the audio connection paired with it
+type sessionData struct {
+	mixer *AudioMixer
+	mu    sync.Mutex
+	// fingerprint of the player's key, which a reconnecting game is matched on. Guests have none.
+	owner string
+	// code the audio connection pairs with, empty once it expired without pairing
+	code string
+	// an audio connection has paired at least once, which keeps the code from expiring
+	paired bool
+	// expires the code if audio hasn't paired in time
+	pairTimer *time.Timer
+	// connections using the session, there is at most one of each
+	games int
+	audio int
+	// tells the connected game whether audio is streaming
+	status chan bool
+	// frees the session once the grace period after both connections left is over
+	closeTimer *time.Timer
+	// counts the grace periods started, so a timer which fired after a newer one was started
+	// leaves the session to it
+	closeGeneration int
+	// the session has been freed, it can't be closed again
+	closed bool
+	// closed when the session is freed, which ends its audio stream
+	done chan struct{}
+}
+
+func newSessionData(owner string) *sessionData {
+	const bytesPerSample = 2
+
+	audio := config.Audio
+	return &sessionData{
+		mixer: NewAudioMixer(audio.Channels, audio.MixAmp, audio.FramesPerWrite, audio.SampleRate, bytesPerSample),
+		owner: owner,
+		done:  make(chan struct{}),
+	}
+}
+
+func (data *sessionData) Code() string {
+	data.mu.Lock()
+	defer data.mu.Unlock()
+	return data.code
+}
+
+// replaces any status the game hasn't read yet, must hold data.mu
+func (data *sessionData) notify(streaming bool) {
+	if data.status == nil {
+		return
+	}
+	select {
+	case <-data.status:
+	default:
+	}
+	data.status <- streaming
+}
+
+// live sessions by the code their audio pairs with and by the player who owns them
+type sessionRegistry struct {
+	mu      sync.Mutex
+	byCode  map[string]*sessionData
+	byOwner map[string]*sessionData
+	// when each client recently tried a code no game was waiting for, by pairClient
+	failedPairs map[string][]time.Time
+}
+
+var sessions = &sessionRegistry{byCode: map[string]*sessionData{}, byOwner: map[string]*sessionData{}, failedPairs: map[string][]time.Time{}}
+
+func newPairCode() (string, error) {
+	random := make([]byte, pairCodeLength)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	code := make([]byte, pairCodeLength)
+	for i, b := range random {
+		code[i] = pairCodeAlphabet[int(b)%len(pairCodeAlphabet)]
+	}
+	return string(code), nil
+}
+
+// gives the session a fresh code, which expires after PairTimeout unless audio pairs with it
+func (r *sessionRegistry) register(data *sessionData) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	code := ""
+	for code == "" || r.byCode[code] != nil {
+		var err error
+		code, err = newPairCode()
+		if err != nil {
+			return "", fmt.Errorf("failed to generate pairing code: %w", err)
+		}
+	}
+	r.byCode[code] = data
+	data.mu.Lock()
+	defer data.mu.Unlock()
+	data.code = code
+	data.pairTimer = time.AfterFunc(PairTimeout, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		data.mu.Lock()
+		defer data.mu.Unlock()
+		if data.paired || data.code != code {
+			return
+		}
+		delete(r.byCode, code)
+		data.code = ""
+		data.pairTimer = nil
+		log.Info("pairing code expired", "code", code)
+	})
+	return code, nil
+}
+
+// attaches a game connection, giving back the player's session if they left it less than
+// SessionGrace ago. The channel reports the audio connection coming and going.
+func (r *sessionRegistry) joinGame(owner string) (*sessionData, <-chan bool, error) {
+	r.mu.Lock()
+	data := r.byOwner[owner]
+	if data != nil {
+		data.mu.Lock()
+		if data.games == 0 {
+			data.stopCloseTimer()
+			data.games++
+			data.status = make(chan bool, 1)
+			data.notify(data.audio > 0)
+			status := data.status
+			data.mu.Unlock()
+			r.mu.Unlock()
+			log.Info("resumed session", "owner", owner, "code", data.Code())
+			return data, status, nil
+		}
+		data.mu.Unlock()
+		// the player is already playing in another terminal, which keeps the session
+		owner = ""
+	}
+
+	data = newSessionData(owner)
+	data.games = 1
+	data.status = make(chan bool, 1)
+	if owner != "" {
+		r.byOwner[owner] = data
+	}
+	r.mu.Unlock()
+
+	code, err := r.register(data)
+	if err != nil {
+		r.leaveGame(data)
+		return nil, nil, err
+	}
+	log.Info("created session", "owner", owner, "code", code)
+	return data, data.status, nil
+}
+
+// detaches the game connection, freeing the session after the grace period once audio has left
+// too, unless either comes back
+func (r *sessionRegistry) leaveGame(data *sessionData) {
+	data.mu.Lock()
+	defer data.mu.Unlock()
+
+	data.games--
+	// wakes up anything still waiting on the status of the closed game
+	close(data.status)
+	data.status = nil
+	if data.games > 0 {
+		return
+	}
+	// the song the game was playing has nothing to go along with anymore, and a game which left
+	// while paused shouldn't keep the mixer silent
+	data.mixer.StopAll()
+	data.mixer.Resume()
+	log.Info("game left session", "owner", data.owner, "code", data.code)
+	r.closeLater(data)
+}
+
+// starts the grace period after which the session is freed if neither connection is left,
+// must hold data.mu
+func (r *sessionRegistry) closeLater(data *sessionData) {
+	if data.games > 0 || data.audio > 0 {
+		return
+	}
+	data.stopCloseTimer()
+	data.closeGeneration++
+	generation := data.closeGeneration
+	log.Info("session unused", "owner", data.owner, "code", data.code, "grace", SessionGrace)
+	data.closeTimer = time.AfterFunc(SessionGrace, func() {
+		r.close(data, generation)
+	})
+}
+
+// keeps a session which got a connection back from being freed, must hold data.mu
+func (data *sessionData) stopCloseTimer() {
+	if data.closeTimer != nil {
+		data.closeTimer.Stop()
+		data.closeTimer = nil
+	}
+}
+
+// the key wrong codes are counted under, the client's address and the fingerprint of its key if
+// it has one
+func pairClient(host string, fingerprint string) string {
+	if fingerprint == "" {
+		return host
+	}
+	return host + " " + fingerprint
+}
+
+// attaches an audio connection to the session waiting for the code. The code stays valid after
+// pairing so the audio can reconnect, but only one audio connection streams at a time. A client
+// which keeps trying wrong codes is turned away for a while.
+func (r *sessionRegistry) joinAudio(code string, host string, fingerprint string) (*sessionData, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for failedClient, failures := range r.failedPairs {
+		failures = slices.DeleteFunc(failures, func(t time.Time) bool { return now.Sub(t) > pairAttemptWindow })
+		if len(failures) == 0 {
+			delete(r.failedPairs, failedClient)
+		} else {
+			r.failedPairs[failedClient] = failures
+		}
+	}
+	client := pairClient(host, fingerprint)
+	if len(r.failedPairs[client]) >= maxFailedPairs {
+		return nil, errTooManyCodes
+	}
+
+	data, ok := r.byCode[strings.ToUpper(strings.TrimSpace(code))]
+	if !ok {
+		r.failedPairs[client] = append(r.failedPairs[client], now)
+		return nil, errUnknownCode
+	}
+	data.mu.Lock()
+	defer data.mu.Unlock()
+	if data.audio > 0 {
+		return nil, errAlreadyPaired
+	}
+	data.stopCloseTimer()
+	if data.pairTimer != nil {
+		data.pairTimer.Stop()
+		data.pairTimer = nil
+	}
+	data.paired = true
+	data.audio++
+	data.notify(true)
+	log.Info("audio joined session", "owner", data.owner, "code", data.code)
+	return data, nil
+}
+
+// detaches the audio connection, freeing the session after the grace period if the game has
+// already left
+func (r *sessionRegistry) leaveAudio(data *sessionData) {
+	data.mu.Lock()
+	defer data.mu.Unlock()
+	data.audio--
+	data.notify(false)
+	log.Info("audio left session", "owner", data.owner, "code", data.code)
+	r.closeLater(data)
+}
+
+// frees the session once the grace period started as generation is over, ending its audio
+// stream and playbacks
+func (r *sessionRegistry) close(data *sessionData, generation int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data.mu.Lock()
+	defer data.mu.Unlock()
+
+	// a connection came back while the timer was firing, and may have left again since, in which
+	// case the newer timer frees the session
+	if data.closed || data.games > 0 || data.audio > 0 || generation != data.closeGeneration {
+		return
+	}
+	data.closed = true
+	data.closeTimer = nil
+	if data.pairTimer != nil {
+		data.pairTimer.Stop()
+		data.pairTimer = nil
+	}
+	if data.code != "" {
+		delete(r.byCode, data.code)
+	}
+	if data.owner != "" && r.byOwner[data.owner] == data {
+		delete(r.byOwner, data.owner)
+	}
+	close(data.done)
+	data.mixer.StopAll()
+	log.Info("closed session", "owner", data.owner, "code", data.code)
+	data.code = ""
+}
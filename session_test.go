@@ -0,0 +1,236 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// a registry of its own, with the default config the sessions' mixers are made from
+func newTestRegistry() *sessionRegistry {
+	if config == nil {
+		defaults := DefaultConfig()
+		config = &defaults
+	}
+	return &sessionRegistry{byCode: map[string]*sessionData{}, byOwner: map[string]*sessionData{}, failedPairs: map[string][]time.Time{}}
+}
+
+// reports whether the session was freed within twice the grace period
+func freed(data *sessionData) bool {
+	select {
+	case <-data.done:
+		return true
+	case <-time.After(2 * SessionGrace):
+		return false
+	}
+}
+
+func TestSessionFreedAfterBothLeave(t *testing.T) {
+	grace := SessionGrace
+	SessionGrace = 20 * time.Millisecond
+	defer func() { SessionGrace = grace }()
+
+	tests := []struct {
+		name string
+		// leaves in this order, true for the game and false for the audio
+		order []bool
+	}{
+		{"game then audio", []bool{true, false}},
+		{"audio then game", []bool{false, true}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newTestRegistry()
+			data, _, err := r.joinGame("owner")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := r.joinAudio(data.Code(), "host", ""); err != nil {
+				t.Fatal(err)
+			}
+
+			leave := func(game bool) {
+				if game {
+					r.leaveGame(data)
+				} else {
+					r.leaveAudio(data)
+				}
+			}
+			leave(test.order[0])
+			if freed(data) {
+				t.Fatal("session was freed while a connection was left")
+			}
+			leave(test.order[1])
+			if !freed(data) {
+				t.Fatal("session was not freed after both connections left")
+			}
+			if r.byOwner["owner"] != nil || len(r.byCode) != 0 {
+				t.Error("freed session is still registered")
+			}
+		})
+	}
+}
+
+func TestSessionStaleCloseTimer(t *testing.T) {
+	grace := SessionGrace
+	// the timers are fired by hand instead
+	SessionGrace = time.Hour
+	defer func() { SessionGrace = grace }()
+
+	tests := []struct {
+		name string
+		// the generations of the timers in the order they fire
+		fire []int
+	}{
+		{"old timer first", []int{1, 2}},
+		{"new timer first", []int{2, 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newTestRegistry()
+			data, _, err := r.joinGame("owner")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// leave, come back and leave again, so the first timer is stale once both fire
+			r.leaveGame(data)
+			if _, _, err := r.joinGame("owner"); err != nil {
+				t.Fatal(err)
+			}
+			r.leaveGame(data)
+
+			current := false
+			for _, generation := range test.fire {
+				r.close(data, generation)
+				current = current || generation == 2
+				freed := false
+				select {
+				case <-data.done:
+					freed = true
+				default:
+				}
+				if freed != current {
+					t.Fatalf("after timer %d fired the session is freed %v, want %v", generation, freed, current)
+				}
+			}
+			// firing again after the session is freed does nothing
+			r.close(data, 2)
+		})
+	}
+}
+
+func TestSessionResumedWithinGrace(t *testing.T) {
+	grace := SessionGrace
+	SessionGrace = 20 * time.Millisecond
+	defer func() { SessionGrace = grace }()
+
+	r := newTestRegistry()
+	data, _, err := r.joinGame("owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.leaveGame(data)
+	resumed, _, err := r.joinGame("owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed != data {
+		t.Fatal("reconnecting game got a new session")
+	}
+	if freed(data) {
+		t.Fatal("resumed session was freed")
+	}
+	r.leaveGame(data)
+}
+
+func TestFailedPairLockout(t *testing.T) {
+	r := newTestRegistry()
+	data, _, err := r.joinGame("owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.leaveGame(data)
+
+	for i := range maxFailedPairs {
+		if _, err := r.joinAudio("WRONG1", "shared", "SHA256:typo"); err != errUnknownCode {
+			t.Fatalf("wrong code %d: error = %v, want %v", i, err, errUnknownCode)
+		}
+	}
+	// even the right code is turned away once a client is locked out
+	if _, err := r.joinAudio(data.Code(), "shared", "SHA256:typo"); err != errTooManyCodes {
+		t.Errorf("right code after too many wrong ones: error = %v, want %v", err, errTooManyCodes)
+	}
+	// clients without a key are only told apart by their address
+	if _, err := r.joinAudio("WRONG1", "shared", ""); err != errUnknownCode {
+		t.Errorf("keyless client on the same address: error = %v, want %v", err, errUnknownCode)
+	}
+
+	// another player behind the same address can still pair, with the code in any case and spacing
+	joined, err := r.joinAudio(" "+strings.ToLower(data.Code())+" ", "shared", "SHA256:player")
+	if err != nil || joined != data {
+		t.Fatalf("right code from another key on the address: error = %v", err)
+	}
+	if _, err := r.joinAudio(data.Code(), "other", ""); err != errAlreadyPaired {
+		t.Errorf("second audio connection: error = %v, want %v", err, errAlreadyPaired)
+	}
+	r.leaveAudio(data)
+
+	// failures older than the window are forgotten
+	client := pairClient("shared", "SHA256:typo")
+	for i := range r.failedPairs[client] {
+		r.failedPairs[client][i] = r.failedPairs[client][i].Add(-2 * pairAttemptWindow)
+	}
+	if _, err := r.joinAudio(data.Code(), "shared", "SHA256:typo"); err != nil {
+		t.Errorf("right code after the window: error = %v", err)
+	}
+	r.leaveAudio(data)
+}
+
+func TestPairTimer(t *testing.T) {
+	timeout := PairTimeout
+	PairTimeout = 20 * time.Millisecond
+	defer func() { PairTimeout = timeout }()
+
+	r := newTestRegistry()
+	expiring, _, err := r.joinGame("expiring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.leaveGame(expiring)
+	paired, _, err := r.joinGame("paired")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.leaveGame(paired)
+	if _, err := r.joinAudio(paired.Code(), "host", ""); err != nil {
+		t.Fatal(err)
+	}
+	defer r.leaveAudio(paired)
+
+	// pairing is done with the timer
+	paired.mu.Lock()
+	if paired.pairTimer != nil {
+		t.Error("paired session still has a pairing timer")
+	}
+	paired.mu.Unlock()
+
+	time.Sleep(4 * PairTimeout)
+	if code := expiring.Code(); code != "" {
+		t.Errorf("code %q didn't expire", code)
+	}
+	if paired.Code() == "" {
+		t.Error("code of the paired session expired")
+	}
+}
+
+func TestPairCode(t *testing.T) {
+	for range 100 {
+		code, err := newPairCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(code) != pairCodeLength || strings.Trim(code, pairCodeAlphabet) != "" {
+			t.Fatalf("newPairCode() = %q, want %d characters from %q", code, pairCodeLength, pairCodeAlphabet)
+		}
+	}
+}
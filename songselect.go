@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea/v2"
+	"github.com/charmbracelet/lipgloss/v2"
+	"github.com/charmbracelet/log"
+)
+
+const (
+	SORT_TITLE = iota
+	SORT_ARTIST
+	SORT_GENRE
+	SORT_MAX = iota - 1
+)
+
+// how long a preview plays for when the chart doesn't say
+const defaultPreviewLength = 30 * time.Second
+
+// songs shown in the list at once
+const songSelectRows = 15
+
+type SongSelect struct {
+	width  int
+	height int
+	menu   Menu
+	// the library songs which match the filter, in sort order
+	songs    []Song
+	selected int
+	sortBy   int
+	filter   string
+	// typed keys go to the filter instead of moving around
+	filtering bool
+	preview   *PlaybackGroup
+}
+
+func NewSongSelect(menu Menu) SongSelect {
+	m := SongSelect{width: menu.width, height: menu.height, menu: menu}
+	m.refresh()
+	return m
+}
+
+func (m SongSelect) Init() tea.Cmd {
+	return nil
+}
+
+// rebuilds the list after the filter or sort changed, keeping the highlighted song if it is still there
+func (m *SongSelect) refresh() {
+	current := ""
+	if m.selected < len(m.songs) {
+		current = m.songs[m.selected].Dir
+	}
+
+	filter := strings.ToLower(m.filter)
+	m.songs = []Song{}
+	for _, song := range m.menu.library.Songs {
+		if filter == "" ||
+			strings.Contains(strings.ToLower(song.Title), filter) ||
+			strings.Contains(strings.ToLower(song.Artist), filter) ||
+			strings.Contains(strings.ToLower(song.Genre), filter) {
+			m.songs = append(m.songs, song)
+		}
+	}
+
+	key := func(song Song) string {
+		switch m.sortBy {
+		case SORT_ARTIST:
+			return strings.ToLower(song.Artist + "\x00" + song.Title)
+		case SORT_GENRE:
+			return strings.ToLower(song.Genre + "\x00" + song.Title)
+		}
+		return strings.ToLower(song.Title)
+	}
+	slices.SortStableFunc(m.songs, func(a, b Song) int {
+		return strings.Compare(key(a), key(b))
+	})
+
+	m.selected = 0
+	for i := range m.songs {
+		if m.songs[i].Dir == current {
+			m.selected = i
+		}
+	}
+	// the preview carries on while typing a filter that keeps the same song highlighted
+	if m.preview == nil || m.selected >= len(m.songs) || m.songs[m.selected].Dir != current {
+		m.playPreview()
+	}
+}
+
+// plays the preview of the highlighted song, stopping the previous one
+func (m *SongSelect) playPreview() {
+	if m.preview != nil {
+		m.preview.Stop()
+		m.preview = nil
+	}
+	if m.selected >= len(m.songs) {
+		return
+	}
+	song := m.songs[m.selected]
+	if len(song.Stems) == 0 {
+		return
+	}
+
+	start := time.Duration(song.Chart.PreviewStart * float64(time.Second))
+	end := time.Duration(song.Chart.PreviewEnd * float64(time.Second))
+	if start == 0 && end == 0 {
+		// no preview set, so start somewhere in the song rather than at the quiet intro
+		start = time.Duration(song.Length / 3 * float64(time.Second))
+	}
+	if end <= start {
+		end = start + defaultPreviewLength
+	}
+
+	preview, err := m.menu.mixer.PlayGroup(song.Stems, 0.8, start, end)
+	if err != nil {
+		log.Error("failed to play preview", "song", song.ID, "error", err)
+		return
+	}
+	m.preview = preview
+}
+
+// picks the song select back up after the track select, starting the preview again
+func (m SongSelect) resume() (tea.Model, tea.Cmd) {
+	m.playPreview()
+	return m, nil
+}
+
+func (m SongSelect) stopPreview() {
+	if m.preview != nil {
+		m.preview.Stop()
+	}
+}
+
+func (m SongSelect) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if cmd, ok := m.menu.updateShared(msg, &m.width, &m.height); ok {
+		return m, cmd
+	}
+	switch msg := msg.(type) {
+	case tea.KeyPressMsg:
+		if m.filtering {
+			switch msg.String() {
+			case "enter", "esc":
+				m.filtering = false
+			case "backspace":
+				if m.filter != "" {
+					runes := []rune(m.filter)
+					m.filter = string(runes[:len(runes)-1])
+					m.refresh()
+				}
+			default:
+				if msg.Key().Text != "" {
+					m.filter += msg.Key().Text
+					m.refresh()
+				}
+			}
+			return m, nil
+		}
+
+		switch msg.String() {
+		case "down", "j":
+			if m.selected < len(m.songs)-1 {
+				m.selected++
+				m.playPreview()
+			}
+		case "up", "k":
+			if m.selected > 0 {
+				m.selected--
+				m.playPreview()
+			}
+		case "tab":
+			m.sortBy = (m.sortBy + 1) % (SORT_MAX + 1)
+			m.refresh()
+		case "/":
+			m.filtering = true
+		case "f":
+			if m.selected < len(m.songs) {
+				m.menu.profile.ToggleFavorite(m.songs[m.selected].ID)
+				m.menu.saveProfile()
+			}
+		case "q", "esc":
+			m.stopPreview()
+			return m.menu.resume()
+		case "space", "enter":
+			if m.selected >= len(m.songs) {
+				return m, nil
+			}
+			m.stopPreview()
+			trackSelect := NewTrackSelect(m, m.songs[m.selected])
+			return trackSelect, trackSelect.Init()
+		}
+	}
+	return m, nil
+}
+
+func formatLength(seconds float64) string {
+	return fmt.Sprintf("%d:%02d", int(seconds)/60, int(seconds)%60)
+}
+
+func (m SongSelect) View() tea.View {
+	sorts := ""
+	for i := range SORT_MAX + 1 {
+		var name string
+		switch i {
+		case SORT_TITLE:
+			name = "Title"
+		case SORT_ARTIST:
+			name = "Artist"
+		case SORT_GENRE:
+			name = "Genre"
+		}
+		color := subtle
+		if m.sortBy == i {
+			color = highlight
+		}
+		sorts += lipgloss.NewStyle().Foreground(color).Bold(m.sortBy == i).Render(name) + "  "
+	}
+	header := "Sort (tab): " + sorts
+
+	filter := "Filter (/): " + m.filter
+	if m.filtering {
+		filter += lipgloss.NewStyle().Foreground(highlight).Render("█")
+	}
+
+	// keep the highlighted song in the middle of the list where possible
+	first := max(0, min(m.selected-songSelectRows/2, len(m.songs)-songSelectRows))
+	list := ""
+	for i := first; i < min(first+songSelectRows, len(m.songs)); i++ {
+		song := m.songs[i]
+		line := song.Title
+		if song.Artist != "" {
+			line += " - " + song.Artist
+		}
+		if m.menu.profile.IsFavorite(song.ID) {
+			line = "★ " + line
+		}
+		style := lipgloss.NewStyle().Foreground(subtle).Width(50).MaxWidth(50)
+		if i == m.selected {
+			style = style.Foreground(highlight).Bold(true)
+			line = "▸ " + line
+		} else {
+			line = "  " + line
+		}
+		list = lipgloss.JoinVertical(0, list, style.Render(line))
+	}
+	if len(m.songs) == 0 {
+		list = lipgloss.NewStyle().Foreground(subtle).Width(50).Render("No songs match")
+	}
+
+	details := ""
+	if m.selected < len(m.songs) {
+		song := m.songs[m.selected]
+		field := func(name string, value string) string {
+			if value == "" {
+				value = "-"
+			}
+			return lipgloss.NewStyle().Foreground(subtle).Width(9).Render(name) + lipgloss.NewStyle().Foreground(normal).Render(value)
+		}
+		details = lipgloss.JoinVertical(0,
+			lipgloss.NewStyle().Foreground(highlight).Bold(true).Render(song.Title),
+			"",
+			field("Artist", song.Artist),
+			field("Album", song.Album),
+			field("Genre", song.Genre),
+			field("Year", song.Year),
+			field("Charter", song.Charter),
+			field("Length", formatLength(song.Length)),
+		)
+	}
+
+	box := lipgloss.NewStyle().Border(lipgloss.NormalBorder()).BorderForeground(subtle).Padding(1, 2)
+	result := lipgloss.JoinVertical(0,
+		header,
+		filter,
+		"",
+		lipgloss.JoinHorizontal(0,
+			box.Height(songSelectRows).Render(list),
+			"  ",
+			box.Width(50).Height(songSelectRows).Render(details),
+		),
+		lipgloss.NewStyle().Foreground(subtle).Render("enter play • f favorite • esc back"),
+	)
+	result = lipgloss.Place(m.width, m.height, 0.5, 0.5, result)
+	view := tea.NewView(result)
+	view.KeyReleases = true
+	return view
+}